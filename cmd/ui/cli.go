@@ -0,0 +1,660 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alardiians/SwissArmyToolkit/core"
+)
+
+// cliCmd is one invocation of a headless subcommand, e.g. "proxy start".
+type cliCmd struct {
+	name string
+	fs   *flag.FlagSet
+	json *bool
+}
+
+type cliFunc func(c *cliCmd, args []string) error
+
+// cliCommands maps "command subcommand" to its implementation. Each calls the
+// same core functions as the matching HTTP handler.
+var cliCommands = map[string]map[string]cliFunc{
+	"proxy": {
+		"start":  cliProxyStart,
+		"stop":   cliProxyStop,
+		"status": cliProxyStatus,
+	},
+	"agent": {
+		"cmd": cliAgentCmd,
+	},
+	"files": {
+		"serve":      cliFilesServe,
+		"ls":         cliFilesList,
+		"cmd":        cliFilesCmd,
+		"upload-cmd": cliFilesUploadCmd,
+		"link":       cliFilesLink,
+	},
+	"scout": {
+		"ssh":   cliScout(core.FSProtocolSSH),
+		"smb":   cliScout(core.FSProtocolSMB),
+		"winrm": cliScout(core.FSProtocolEvilWinRM),
+	},
+	"install": {
+		"ligolo": cliInstallLigolo,
+	},
+	"config": {
+		"get": cliConfigGet,
+		"set": cliConfigSet,
+	},
+}
+
+func cliUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: pivotonthego [flags]                      start the web UI")
+	fmt.Fprintln(w, "       pivotonthego [flags] <command> <sub> [args] run headless")
+	fmt.Fprintln(w, "\ncommands (add --json for machine-readable output, -h for options):")
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		subs := make([]string, 0, len(cliCommands[name]))
+		for sub := range cliCommands[name] {
+			subs = append(subs, sub)
+		}
+		sort.Strings(subs)
+		fmt.Fprintf(w, "  %-8s %s\n", name, strings.Join(subs, "|"))
+	}
+	fmt.Fprintln(w, "\nflags:")
+	flag.PrintDefaults()
+}
+
+// runCLI runs a headless subcommand and returns the process exit code.
+func runCLI(args []string) int {
+	subs, ok := cliCommands[args[0]]
+	if !ok || len(args) < 2 || subs[args[1]] == nil {
+		cliUsage(os.Stderr)
+		return 2
+	}
+
+	name := args[0] + " " + args[1]
+	c := &cliCmd{name: name, fs: flag.NewFlagSet(name, flag.ContinueOnError)}
+	c.json = c.fs.Bool("json", false, "print JSON instead of text")
+
+	err := subs[args[1]](c, args[2:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		var fieldErrs core.FieldErrors
+		switch {
+		case *c.json && errors.As(err, &fieldErrs):
+			c.emit(map[string]interface{}{"error": "invalid config", "fields": fieldErrs}, "")
+		case *c.json:
+			c.emit(map[string]string{"error": err.Error()}, "")
+		default:
+			fmt.Fprintf(os.Stderr, "pivotonthego %s: %v\n", name, err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// parse parses the subcommand's flags and returns the positional arguments.
+func (c *cliCmd) parse(args []string) ([]string, error) {
+	if err := c.fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return c.fs.Args(), nil
+}
+
+// emit prints v as JSON with --json, or text otherwise.
+func (c *cliCmd) emit(v interface{}, text string) {
+	if *c.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(v)
+		return
+	}
+	if text != "" {
+		fmt.Println(strings.TrimRight(text, "\n"))
+	}
+}
+
+func cliLoadConfig() (core.Config, error) {
+	cfg, err := core.LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return cfg, fmt.Errorf("failed to load config: %w", err)
+	}
+	return core.SanitizeConfig(cfg), nil
+}
+
+// cliProxies returns a registry that has re-attached to any proxy started by
+// the UI or an earlier command.
+func cliProxies(cfg core.Config) *core.ProxyRegistry {
+	logDir, err := core.ProxyLogDir()
+	if err != nil {
+		logDir = ""
+	}
+	reg := core.NewProxyRegistry(logDir)
+	reg.AdoptAll(cfg)
+	return reg
+}
+
+func cliProxyStart(c *cliCmd, args []string) error {
+	name := c.fs.String("name", core.DefaultProxyInstance, "proxy instance")
+	if _, err := c.parse(args); err != nil {
+		return err
+	}
+	cfg, err := cliLoadConfig()
+	if err != nil {
+		return err
+	}
+	instCfg, err := core.ProxyInstanceConfig(cfg, *name)
+	if err != nil {
+		return err
+	}
+	reg := cliProxies(cfg)
+	defer reg.Close()
+	sup, err := reg.Supervisor(*name)
+	if err != nil {
+		return err
+	}
+	if err := sup.Start(instCfg); err != nil {
+		return err
+	}
+
+	// Give the proxy time to fail on a bad config or busy port. It keeps
+	// running after this command exits; the UI re-attaches to it on start.
+	time.Sleep(2 * time.Second)
+	st := sup.Status()
+	if !st.Running() {
+		return fmt.Errorf("proxy exited: %s %s", st.ExitError, strings.Join(st.LastStderr, " | "))
+	}
+	c.emit(map[string]interface{}{"status": "started", "name": *name, "proxy": st},
+		fmt.Sprintf("proxy %s started (pid %d) on %s", *name, st.PID, st.Addr))
+	return nil
+}
+
+func cliProxyStop(c *cliCmd, args []string) error {
+	name := c.fs.String("name", core.DefaultProxyInstance, "proxy instance")
+	if _, err := c.parse(args); err != nil {
+		return err
+	}
+	cfg, err := cliLoadConfig()
+	if err != nil {
+		return err
+	}
+	reg := cliProxies(cfg)
+	defer reg.Close()
+	sup, err := reg.Supervisor(*name)
+	if err != nil {
+		return err
+	}
+	if !sup.Stop() {
+		c.emit(map[string]string{"status": "not_running", "name": *name}, "proxy "+*name+" is not running")
+		return nil
+	}
+	c.emit(map[string]string{"status": "stopped", "name": *name}, "proxy "+*name+" stopped")
+	return nil
+}
+
+func cliProxyStatus(c *cliCmd, args []string) error {
+	if _, err := c.parse(args); err != nil {
+		return err
+	}
+	cfg, err := cliLoadConfig()
+	if err != nil {
+		return err
+	}
+	reg := cliProxies(cfg)
+	defer reg.Close()
+
+	list := []proxyInstanceInfo{}
+	var text strings.Builder
+	tw := tabwriter.NewWriter(&text, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATE\tPID\tLISTEN\tUPTIME")
+	for _, name := range core.ProxyInstanceNames(cfg) {
+		instCfg, err := reg.InstanceConfig(cfg, name)
+		if err != nil {
+			continue
+		}
+		sup, err := reg.Supervisor(name)
+		if err != nil {
+			continue
+		}
+		st := sup.Status()
+		list = append(list, proxyInstanceInfo{
+			Name:         name,
+			Bind:         instCfg.ProxyBind,
+			Port:         instCfg.ProxyPort,
+			Binary:       instCfg.ProxyBinary,
+			Status:       st,
+			AgentLinux:   core.AgentCmdLinux(instCfg),
+			AgentWindows: core.AgentCmdWindows(instCfg),
+		})
+		pid, uptime := "-", "-"
+		if st.Running() {
+			pid = fmt.Sprint(st.PID)
+			uptime = (time.Duration(st.UptimeSeconds) * time.Second).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s:%d\t%s\n", name, st.State, pid, instCfg.ProxyBind, instCfg.ProxyPort, uptime)
+	}
+	tw.Flush()
+	c.emit(map[string]interface{}{"proxies": list}, text.String())
+	return nil
+}
+
+func cliAgentCmd(c *cliCmd, args []string) error {
+	var opts core.AgentCommandOptions
+	c.fs.StringVar(&opts.OS, "os", "", "target OS: linux, windows or darwin (default: every published agent)")
+	c.fs.StringVar(&opts.Arch, "arch", "", "target architecture (default amd64)")
+	c.fs.StringVar(&opts.Download, "download", "", "download template, e.g. curl, wget, iwr")
+	c.fs.StringVar(&opts.Run, "run", "", "run style: foreground, background or retry")
+	c.fs.StringVar(&opts.Bind, "bind", "", "bind mode listen address (agent listens, proxy connects)")
+	c.fs.IntVar(&opts.RetryDelay, "retry-delay", 0, "seconds between reconnects in retry style")
+	name := c.fs.String("name", core.DefaultProxyInstance, "proxy instance the agent connects to")
+	if _, err := c.parse(args); err != nil {
+		return err
+	}
+	if opts.OS != "" && opts.OS != "linux" && opts.OS != "windows" && opts.OS != "darwin" {
+		return errors.New("invalid os")
+	}
+
+	cfg, err := cliLoadConfig()
+	if err != nil {
+		return err
+	}
+	reg := cliProxies(cfg)
+	defer reg.Close()
+	cfg, err = reg.InstanceConfig(cfg, *name)
+	if err != nil {
+		return err
+	}
+	tmpl, err := core.LoadAgentTemplates()
+	if err != nil {
+		return fmt.Errorf("failed to load agent templates: %w", err)
+	}
+
+	if opts.OS == "" {
+		cmds, err := core.PublishedAgentCommands(cfg, tmpl, opts)
+		if err != nil {
+			return err
+		}
+		var text strings.Builder
+		for _, cmd := range cmds {
+			fmt.Fprintf(&text, "# %s/%s\n%s\n", cmd.OS, cmd.Arch, cmd.Command)
+		}
+		c.emit(map[string]interface{}{"commands": cmds}, text.String())
+		return nil
+	}
+	cmd, err := core.GenerateAgentCommand(cfg, tmpl, opts)
+	if err != nil {
+		return err
+	}
+	text := cmd.Command
+	if cmd.ProxyCommand != "" {
+		text += "\n# then, in the proxy console:\n" + cmd.ProxyCommand
+	}
+	c.emit(cmd, text)
+	return nil
+}
+
+func cliFilesServe(c *cliCmd, args []string) error {
+	if _, err := c.parse(args); err != nil {
+		return err
+	}
+	cfg, err := cliLoadConfig()
+	if err != nil {
+		return err
+	}
+	srv, ln, err := newFileServer(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	scheme := "http"
+	if cfg.FileTLS {
+		scheme = "https"
+	}
+	status := map[string]string{"status": "serving", "addr": srv.Addr, "scheme": scheme, "directory": cfg.FileDirectory}
+	text := fmt.Sprintf("serving %s on %s://%s (Ctrl-C to stop)", cfg.FileDirectory, scheme, srv.Addr)
+	if fp := core.FileServerCertFingerprint(core.SanitizeConfig(cfg)); fp != "" {
+		status["fingerprint"] = fp
+		text += "\ncertificate fingerprint: " + fp
+	}
+	c.emit(status, text)
+	defer fileAccess.Close()
+	go func() {
+		var seq uint64
+		for {
+			entries, notify := fileAccess.Since(seq)
+			for _, a := range entries {
+				log.Println(a.Summary())
+				seq = a.Seq
+			}
+			select {
+			case <-notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Println("file server stopped")
+	return nil
+}
+
+func cliFilesList(c *cliCmd, args []string) error {
+	rest, err := c.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 1 {
+		return errors.New("usage: files ls [path]")
+	}
+	rel := ""
+	if len(rest) == 1 {
+		rel = rest[0]
+	}
+	listing, err := core.ListFileServerDir(rel)
+	if err != nil {
+		return err
+	}
+	var text strings.Builder
+	tw := tabwriter.NewWriter(&text, 0, 4, 2, ' ', 0)
+	for _, e := range listing.Entries {
+		name := e.Name
+		if e.IsDir {
+			name += "/"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", e.Size, e.ModTime.Format("2006-01-02 15:04"), name)
+	}
+	tw.Flush()
+	c.emit(listing, text.String())
+	return nil
+}
+
+func cliFilesCmd(c *cliCmd, args []string) error {
+	osName := c.fs.String("os", "linux", "target OS: linux or windows")
+	rest, err := c.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errors.New("usage: files cmd [--os linux|windows] <path>")
+	}
+	if err := core.ValidateFileCommand(*osName, rest[0]); err != nil {
+		return err
+	}
+	cfg, err := cliLoadConfig()
+	if err != nil {
+		return err
+	}
+	cmd, err := core.FileDownloadCommand(cfg, *osName, rest[0], nil)
+	if err != nil {
+		return err
+	}
+	c.emit(cmd, cmd.Command)
+	return nil
+}
+
+func cliFilesLink(c *cliCmd, args []string) error {
+	osName := c.fs.String("os", "linux", "target OS of the printed command: linux or windows")
+	ttl := c.fs.Int("ttl", 0, "minutes until the link expires (default file_link_ttl_minutes)")
+	maxDownloads := c.fs.Int("max", 0, "downloads allowed (default file_link_max_downloads)")
+	sourceIP := c.fs.String("source-ip", "", "only allow this IP address or CIDR range")
+	rest, err := c.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errors.New("usage: files link [--ttl minutes] [--max n] [--source-ip ip|cidr] [--os linux|windows] <path>")
+	}
+	if *ttl < 0 || *maxDownloads < 0 {
+		return errors.New("--ttl and --max must not be negative")
+	}
+	if err := core.ValidateFileCommand(*osName, rest[0]); err != nil {
+		return err
+	}
+	cfg, err := cliLoadConfig()
+	if err != nil {
+		return err
+	}
+	cmd, err := core.FileDownloadCommand(cfg, *osName, rest[0], &core.FileLinkOptions{
+		TTL:          time.Duration(*ttl) * time.Minute,
+		MaxDownloads: *maxDownloads,
+		SourceIP:     *sourceIP,
+	})
+	if err != nil {
+		return err
+	}
+	l := cmd.Link
+	text := fmt.Sprintf("%s\n# %s, expires %s, %d download(s)", cmd.Command, cmd.URL, l.ExpiresAt.Format(time.RFC3339), l.MaxDownloads)
+	if l.SourceIP != "" {
+		text += ", only from " + l.SourceIP
+	}
+	c.emit(cmd, text)
+	return nil
+}
+
+func cliFilesUploadCmd(c *cliCmd, args []string) error {
+	rest, err := c.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errors.New("usage: files upload-cmd <path on target>")
+	}
+	cfg, err := cliLoadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg, err = core.EnsureUploadToken(cfg); err != nil {
+		return err
+	}
+	cmds, err := core.FileUploadCommands(cfg, rest[0])
+	if err != nil {
+		return err
+	}
+	var text strings.Builder
+	for _, cmd := range cmds {
+		fmt.Fprintf(&text, "# %s (%s)\n%s\n", cmd.Tool, cmd.OS, cmd.Command)
+	}
+	c.emit(map[string]interface{}{"commands": cmds}, text.String())
+	return nil
+}
+
+// cliScout returns the scout subcommand for one protocol. The password can
+// come from POTG_SCOUT_PASSWORD to keep it out of the process list.
+func cliScout(protocol core.FSScoutProtocol) cliFunc {
+	return func(c *cliCmd, args []string) error {
+		req := core.FSScoutRequest{Protocol: protocol}
+		c.fs.StringVar(&req.Host, "host", "", "target host")
+		c.fs.IntVar(&req.Port, "port", 0, "target port (default for the protocol)")
+		c.fs.StringVar(&req.Username, "user", "", "username")
+		c.fs.StringVar(&req.Password, "password", "", "password (or env POTG_SCOUT_PASSWORD)")
+		c.fs.StringVar(&req.StartDir, "dir", "", "start directory")
+		c.fs.IntVar(&req.Depth, "depth", 3, "max depth")
+		mode := c.fs.String("mode", string(core.FSModeFast), "fast or stealth")
+		if protocol == core.FSProtocolSMB {
+			c.fs.StringVar(&req.SMBShare, "share", "", "SMB share")
+		}
+		if _, err := c.parse(args); err != nil {
+			return err
+		}
+		req.Mode = core.FSScoutMode(*mode)
+		if req.Password == "" {
+			req.Password = os.Getenv(core.EnvPrefix + "SCOUT_PASSWORD")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		res, err := core.RunFSScout(ctx, req)
+		if err != nil {
+			if res.OutputFile != "" {
+				return fmt.Errorf("%w (partial results in %s)", err, res.OutputFile)
+			}
+			return err
+		}
+		c.emit(res, "results written to "+res.OutputFile)
+		return nil
+	}
+}
+
+func cliInstallLigolo(c *cliCmd, args []string) error {
+	version := c.fs.String("version", "", "release to install (default: the active or latest version)")
+	if _, err := c.parse(args); err != nil {
+		return err
+	}
+	if *version != "" {
+		if err := core.ValidateLigoloVersion(*version); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Progress goes to stderr so --json output stays parseable.
+	lastStage := ""
+	result, err := core.RunSkiddieInstall(ctx, *version, func(p core.SkiddieProgress) {
+		switch {
+		case p.Message != "":
+			fmt.Fprintf(os.Stderr, "[%s] %s\n", p.Stage, p.Message)
+		case p.Stage != lastStage:
+			fmt.Fprintf(os.Stderr, "[%s] %s\n", p.Stage, p.Asset)
+		}
+		lastStage = p.Stage
+	})
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			err = errors.New("install cancelled; run again to resume")
+		}
+		return err
+	}
+	published, err := core.AutoPublishAgents()
+	if err != nil {
+		result.Message += " Publishing agents to the file server failed: " + err.Error()
+	}
+	result.Published = published
+	c.emit(result, result.Message)
+	return nil
+}
+
+func cliConfigGet(c *cliCmd, args []string) error {
+	keys, err := c.parse(args)
+	if err != nil {
+		return err
+	}
+	cfg, err := cliLoadConfig()
+	if err != nil {
+		return err
+	}
+	raw := map[string]json.RawMessage{}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	sources := core.ConfigSources()
+
+	single := len(keys) == 1
+	if len(keys) == 0 {
+		for _, k := range core.ConfigKeys() {
+			keys = append(keys, k.Key)
+		}
+	}
+	values := map[string]json.RawMessage{}
+	var text strings.Builder
+	for _, key := range keys {
+		value, ok := raw[key]
+		if !ok || key == "schema_version" {
+			return fmt.Errorf("unknown config key %q", key)
+		}
+		values[key] = value
+		if single {
+			text.WriteString(cliConfigText(value))
+		} else {
+			fmt.Fprintf(&text, "%s = %s  (%s)\n", key, cliConfigText(value), sources[key])
+		}
+	}
+	c.emit(map[string]interface{}{"config": values, "sources": sources}, text.String())
+	return nil
+}
+
+// cliConfigText shows strings unquoted and string lists comma-separated, the
+// form "config set" accepts.
+func cliConfigText(value json.RawMessage) string {
+	var s string
+	if json.Unmarshal(value, &s) == nil {
+		return s
+	}
+	var list []string
+	if json.Unmarshal(value, &list) == nil {
+		return strings.Join(list, ",")
+	}
+	return string(value)
+}
+
+func cliConfigSet(c *cliCmd, args []string) error {
+	pairs, err := c.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(pairs) == 0 {
+		return errors.New("usage: config set key=value [key=value...]")
+	}
+	cfg, err := cliLoadConfig()
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]bool{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%q is not key=value", pair)
+		}
+		key = strings.ReplaceAll(strings.TrimSpace(key), "-", "_")
+		if cfg, err = core.SetConfigField(cfg, key, value); err != nil {
+			return err
+		}
+		fields[key] = true
+	}
+	// Same checks as POST /api/config.
+	errs := append(core.OverrideConflicts(cfg), core.ValidateConfig(cfg).Only(fields)...)
+	if len(errs) > 0 {
+		return errs
+	}
+	if err := core.SaveConfig(core.SanitizeConfig(cfg)); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	c.emit(map[string]string{"status": "ok"}, "config saved")
+	return nil
+}
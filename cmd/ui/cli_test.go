@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// runCLITest runs a headless command and returns its exit code, stdout and
+// stderr.
+func runCLITest(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	capture := func(f **os.File) func() string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := *f
+		*f = w
+		out := make(chan string)
+		go func() {
+			data, _ := io.ReadAll(r)
+			out <- string(data)
+		}()
+		return func() string {
+			*f = old
+			w.Close()
+			return <-out
+		}
+	}
+	stdout, stderr := capture(&os.Stdout), capture(&os.Stderr)
+	code := runCLI(args)
+	return code, stdout(), stderr()
+}
+
+func TestRunCLIParsing(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{[]string{"nope"}, 2, "", "usage: pivotonthego"},
+		{[]string{"proxy"}, 2, "", "usage: pivotonthego"},
+		{[]string{"proxy", "restart"}, 2, "", "usage: pivotonthego"},
+		{[]string{"config", "get", "-h"}, 0, "", "-json"},
+		{[]string{"config", "get", "--bogus"}, 1, "", "flag provided but not defined: -bogus"},
+		{[]string{"config", "get", "no_such_key"}, 1, "", `pivotonthego config get: unknown config key "no_such_key"`},
+		{[]string{"config", "set"}, 1, "", "usage: config set key=value"},
+		{[]string{"config", "set", "proxy_port"}, 1, "", `"proxy_port" is not key=value`},
+		{[]string{"config", "set", "proxy-port=many"}, 1, "", `proxy_port: "many" is not a number`},
+		{[]string{"files", "cmd"}, 1, "", "usage: files cmd"},
+		{[]string{"agent", "cmd", "--os", "plan9"}, 1, "", "invalid os"},
+		{[]string{"config", "set", "proxy-port=12000", "public_ip=10.10.14.2"}, 0, "config saved", ""},
+		{[]string{"config", "get", "proxy_port"}, 0, "12000\n", ""},
+		{[]string{"agent", "cmd", "--os", "linux", "--bind", "0.0.0.0:4444"}, 0, "./agent -bind 0.0.0.0:4444\n# then, in the proxy console:\nconnect_agent --ip <target>:4444\n", ""},
+	}
+	for _, tt := range tests {
+		code, stdout, stderr := runCLITest(t, tt.args...)
+		if code != tt.code || !strings.Contains(stdout, tt.stdout) || !strings.Contains(stderr, tt.stderr) {
+			t.Errorf("%s: exit %d\nstdout: %s\nstderr: %s", strings.Join(tt.args, " "), code, stdout, stderr)
+		}
+	}
+}
+
+func TestRunCLIJSON(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if code, _, stderr := runCLITest(t, "config", "set", "file_port=8443"); code != 0 {
+		t.Fatalf("config set: exit %d: %s", code, stderr)
+	}
+
+	code, stdout, _ := runCLITest(t, "config", "get", "--json", "file_port")
+	var got struct {
+		Config  map[string]json.RawMessage `json:"config"`
+		Sources map[string]string          `json:"sources"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &got); err != nil || code != 0 {
+		t.Fatalf("config get --json: exit %d, %v: %s", code, err, stdout)
+	}
+	if string(got.Config["file_port"]) != "8443" || got.Sources["file_port"] != "file" || got.Sources["proxy_port"] != "default" {
+		t.Errorf("config get --json = %s", stdout)
+	}
+
+	// Invalid fields are reported per field, on stdout, with exit code 1.
+	code, stdout, stderr := runCLITest(t, "config", "set", "--json", "public_ip=bad_host!", "file_port=0")
+	var failed struct {
+		Error  string `json:"error"`
+		Fields []struct {
+			Field string `json:"field"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &failed); err != nil || code != 1 || stderr != "" {
+		t.Fatalf("config set --json: exit %d, %v\nstdout: %s\nstderr: %s", code, err, stdout, stderr)
+	}
+	if failed.Error != "invalid config" || len(failed.Fields) != 2 {
+		t.Errorf("config set --json = %s", stdout)
+	}
+	if code, stdout, _ := runCLITest(t, "config", "get", "file_port"); code != 0 || stdout != "8443\n" {
+		t.Errorf("a rejected config set was saved: %s", stdout)
+	}
+}
@@ -1,34 +1,93 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/alardiians/SwissArmyToolkit/core"
 	webassets "github.com/alardiians/SwissArmyToolkit/web"
 )
 
-const maxRequestBody = 64 * 1024
+const (
+	maxRequestBody   = 64 * 1024
+	maxArchiveUpload = 256 << 20
+
+	defaultListenAddr = "127.0.0.1:8080"
+)
 
 var (
-	proxyMu  sync.Mutex
-	proxyCmd *exec.Cmd
+	// appCtx is cancelled when the UI receives SIGINT or SIGTERM.
+	appCtx = context.Background()
+
+	proxies *core.ProxyRegistry
+
+	ligoloAPIMu  sync.Mutex
+	ligoloAPI    *core.LigoloAPIClient
+	ligoloAPIKey string
 
 	fileSrvMu sync.Mutex
 	fileSrv   *http.Server
+
+	// fileAccess records requests to the file server. It outlives file
+	// server restarts so the live feed keeps working.
+	fileAccess = core.NewFileAccessLog()
+
+	skiddieMu      sync.Mutex
+	skiddieCancel  context.CancelFunc
+	skiddieEvents  []skiddieEvent
+	skiddieNotify  = make(chan struct{})
+	skiddieRunning bool
+	// skiddieJob numbers Skiddie Mode jobs; skiddieEvents belong to this one.
+	skiddieJob uint64
 )
 
+// skiddieEvent is one entry in the event stream of a Skiddie Mode job.
+type skiddieEvent struct {
+	Job      uint64                `json:"job"`
+	Type     string                `json:"type"`
+	Progress *core.SkiddieProgress `json:"progress,omitempty"`
+	Result   *core.SkiddieResult   `json:"result,omitempty"`
+	Error    string                `json:"error,omitempty"`
+}
+
+// publishSkiddieEvent records an event of the current job and wakes every
+// stream subscriber.
+func publishSkiddieEvent(ev skiddieEvent) {
+	skiddieMu.Lock()
+	defer skiddieMu.Unlock()
+
+	if ev.Job != skiddieJob {
+		return
+	}
+	skiddieEvents = append(skiddieEvents, ev)
+	if ev.Type == "result" || ev.Type == "error" {
+		skiddieRunning = false
+		skiddieCancel = nil
+	}
+	close(skiddieNotify)
+	skiddieNotify = make(chan struct{})
+}
+
 func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -39,6 +98,15 @@ func respondError(w http.ResponseWriter, status int, message string) {
 	respondJSON(w, status, map[string]string{"error": message})
 }
 
+// respondFieldErrors reports config validation failures with the offending
+// field names so the UI can highlight them.
+func respondFieldErrors(w http.ResponseWriter, errs core.FieldErrors) {
+	respondJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error":  "invalid config: " + errs.Error(),
+		"fields": errs,
+	})
+}
+
 func handleGetConfig(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -46,17 +114,20 @@ func handleGetConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cfg, err := core.LoadConfig()
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			cfg = core.DefaultConfig()
-		} else {
-			respondError(w, http.StatusInternalServerError, "failed to load config")
-			return
-		}
+	if errors.Is(err, core.ErrConfigTooNew) {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		respondError(w, http.StatusInternalServerError, "failed to load config")
+		return
 	}
 
-	cfg = core.SanitizeConfig(cfg)
-	respondJSON(w, http.StatusOK, cfg)
+	// sources says where each value came from (flag, env, file or default).
+	respondJSON(w, http.StatusOK, struct {
+		core.Config
+		Sources map[string]string `json:"sources"`
+	}{core.SanitizeConfig(cfg), core.ConfigSources()})
 }
 
 func handlePostConfig(w http.ResponseWriter, r *http.Request) {
@@ -68,15 +139,40 @@ func handlePostConfig(w http.ResponseWriter, r *http.Request) {
 	limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
 	defer limitedBody.Close()
 
-	dec := json.NewDecoder(limitedBody)
+	// Decode over the stored config so fields the UI does not send are kept.
+	cfg, err := core.LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		respondError(w, http.StatusInternalServerError, "failed to load config")
+		return
+	}
+
+	body, err := io.ReadAll(limitedBody)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid config payload")
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
 	dec.DisallowUnknownFields()
 
-	var cfg core.Config
 	if err := dec.Decode(&cfg); err != nil {
 		respondError(w, http.StatusBadRequest, "invalid config payload")
 		return
 	}
 
+	// Only the submitted fields are validated, so a partial update (e.g. the
+	// proxy instances list) isn't blocked by unrelated stored settings.
+	var submitted map[string]json.RawMessage
+	_ = json.Unmarshal(body, &submitted)
+	fields := map[string]bool{}
+	for k := range submitted {
+		fields[k] = true
+	}
+	errs := append(core.OverrideConflicts(cfg), core.ValidateConfig(cfg).Only(fields)...)
+	if len(errs) > 0 {
+		respondFieldErrors(w, errs)
+		return
+	}
+
 	cfg = core.SanitizeConfig(cfg)
 	if err := core.SaveConfig(cfg); err != nil {
 		respondError(w, http.StatusInternalServerError, "failed to save config")
@@ -86,6 +182,64 @@ func handlePostConfig(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
+// proxyInstanceName returns the proxy instance selected by the ?name= query
+// parameter, defaulting to the top-level proxy.
+func proxyInstanceName(r *http.Request) string {
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		return core.DefaultProxyInstance
+	}
+	return name
+}
+
+// proxyInstanceInfo is one entry of the /api/proxies listing.
+type proxyInstanceInfo struct {
+	Name         string           `json:"name"`
+	Bind         string           `json:"bind"`
+	Port         int              `json:"port"`
+	Binary       string           `json:"binary"`
+	Status       core.ProxyStatus `json:"status"`
+	AgentLinux   string           `json:"agent_linux"`
+	AgentWindows string           `json:"agent_windows"`
+}
+
+func handleProxies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	cfg, err := core.LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		respondError(w, http.StatusInternalServerError, "failed to load config")
+		return
+	}
+	cfg = core.SanitizeConfig(cfg)
+
+	list := []proxyInstanceInfo{}
+	for _, name := range core.ProxyInstanceNames(cfg) {
+		instCfg, err := proxies.InstanceConfig(cfg, name)
+		if err != nil {
+			continue
+		}
+		sup, err := proxies.Supervisor(name)
+		if err != nil {
+			continue
+		}
+		list = append(list, proxyInstanceInfo{
+			Name:         name,
+			Bind:         instCfg.ProxyBind,
+			Port:         instCfg.ProxyPort,
+			Binary:       instCfg.ProxyBinary,
+			Status:       sup.Status(),
+			AgentLinux:   core.AgentCmdLinux(instCfg),
+			AgentWindows: core.AgentCmdWindows(instCfg),
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"proxies": list})
+}
+
 func handleStartProxy(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -95,37 +249,362 @@ func handleStartProxy(w http.ResponseWriter, r *http.Request) {
 	limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
 	defer limitedBody.Close()
 
-	proxyMu.Lock()
-	defer proxyMu.Unlock()
+	cfg, err := core.LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		respondError(w, http.StatusInternalServerError, "failed to load config")
+		return
+	}
+
+	name := proxyInstanceName(r)
+	cfg, err = core.ProxyInstanceConfig(cfg, name)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	sup, err := proxies.Supervisor(name)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := sup.Start(cfg); err != nil {
+		if errors.Is(err, core.ErrProxyRunning) {
+			respondError(w, http.StatusConflict, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to start proxy: "+err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "started", "name": name})
+}
+
+func handleStopProxy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
+	defer limitedBody.Close()
+
+	sup, err := proxies.Supervisor(proxyInstanceName(r))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !sup.Stop() {
+		respondJSON(w, http.StatusOK, map[string]string{"status": "not_running"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "stopped"})
+}
 
-	if proxyCmd != nil {
-		respondError(w, http.StatusConflict, "proxy already running")
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
-	cfg, err := core.LoadConfig()
+	sup, err := proxies.Supervisor(proxyInstanceName(r))
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			cfg = core.DefaultConfig()
-		} else {
-			respondError(w, http.StatusInternalServerError, "failed to load config")
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	st := sup.Status()
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"proxy_running": st.Running(),
+		"proxy":         st,
+	})
+}
+
+func handleProxyLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	proxyLog, err := proxies.Log(proxyInstanceName(r))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	n, err := strconv.Atoi(r.URL.Query().Get("lines"))
+	if err != nil || n <= 0 {
+		n = 200
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"log_file": proxyLog.Path(),
+		"lines":    proxyLog.Tail(n),
+	})
+}
+
+// handleProxyLogStream live-tails proxy output as Server-Sent Events. Each
+// event carries the line's sequence number as its id, so reconnecting
+// clients resume where they left off via Last-Event-ID.
+func handleProxyLogStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	proxyLog, err := proxies.Log(proxyInstanceName(r))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	since := r.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = r.URL.Query().Get("since")
+	}
+	seq, _ := strconv.ParseUint(since, 10, 64)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		lines, notify := proxyLog.Since(seq)
+		for _, line := range lines {
+			data, _ := json.Marshal(line)
+			fmt.Fprintf(w, "id: %d\nevent: line\ndata: %s\n\n", line.Seq, data)
+			seq = line.Seq
+		}
+		flusher.Flush()
+
+		select {
+		case <-notify:
+		case <-r.Context().Done():
 			return
 		}
 	}
+}
 
-	cfg = core.SanitizeConfig(cfg)
-	cmd, err := core.StartProxy(cfg)
+func handleAgent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	opts := core.AgentCommandOptions{
+		OS:       q.Get("os"),
+		Arch:     q.Get("arch"),
+		Download: q.Get("download"),
+		Run:      q.Get("run"),
+		Bind:     strings.TrimSpace(q.Get("bind")),
+	}
+	// Without an os, return a command for every published agent build.
+	all := opts.OS == ""
+	if !all && opts.OS != "linux" && opts.OS != "windows" && opts.OS != "darwin" {
+		respondError(w, http.StatusBadRequest, "invalid os")
+		return
+	}
+	if q.Get("mode") == "bind" && opts.Bind == "" {
+		opts.Bind = core.DefaultAgentBindAddr
+	}
+	if v := q.Get("retry_delay"); v != "" {
+		delay, err := strconv.Atoi(v)
+		if err != nil || delay <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid retry_delay")
+			return
+		}
+		opts.RetryDelay = delay
+	}
+
+	cfg, err := core.LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		respondError(w, http.StatusInternalServerError, "failed to load config")
+		return
+	}
+
+	cfg, err = proxies.InstanceConfig(cfg, proxyInstanceName(r))
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "failed to start proxy")
+		respondError(w, http.StatusNotFound, err.Error())
 		return
 	}
 
-	proxyCmd = cmd
+	tmpl, err := core.LoadAgentTemplates()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load agent templates: "+err.Error())
+		return
+	}
+	if all {
+		cmds, err := core.PublishedAgentCommands(cfg, tmpl, opts)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"commands": cmds})
+		return
+	}
+	cmd, err := core.GenerateAgentCommand(cfg, tmpl, opts)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	respondJSON(w, http.StatusOK, map[string]string{"status": "started"})
+	respondJSON(w, http.StatusOK, cmd)
 }
 
-func handleStopProxy(w http.ResponseWriter, r *http.Request) {
+// handleAgentPublish lists the agent builds published into the file server
+// directory (GET) or publishes the installed builds now (POST).
+func handleAgentPublish(w http.ResponseWriter, r *http.Request) {
+	cfg, err := core.LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		respondError(w, http.StatusInternalServerError, "failed to load config")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, http.StatusOK, map[string]interface{}{"published": core.PublishedAgents(cfg)})
+	case http.MethodPost:
+		limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
+		defer limitedBody.Close()
+
+		// The body is optional; rotate picks new random names.
+		var req struct {
+			Rotate bool `json:"rotate"`
+		}
+		dec := json.NewDecoder(limitedBody)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			respondError(w, http.StatusBadRequest, "invalid publish payload")
+			return
+		}
+
+		published, err := core.PublishAgents(cfg, req.Rotate)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to publish agents: "+err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"published": published})
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAgentTemplates returns the agent command templates (GET) or saves the
+// user's overrides (POST).
+func handleAgentTemplates(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		tmpl, err := core.LoadAgentTemplates()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to load agent templates: "+err.Error())
+			return
+		}
+		path, _ := core.AgentTemplatesPath()
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"templates": tmpl,
+			"path":      path,
+		})
+	case http.MethodPost:
+		limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
+		defer limitedBody.Close()
+
+		var tmpl core.AgentTemplates
+		dec := json.NewDecoder(limitedBody)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&tmpl); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid templates payload")
+			return
+		}
+		if err := core.ValidateAgentTemplates(tmpl); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := core.SaveAgentTemplates(tmpl); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to save agent templates")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "saved"})
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func handleSkiddie(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
+	defer limitedBody.Close()
+
+	// The body is optional; an empty POST keeps the active version.
+	var req struct {
+		Version string `json:"version"`
+	}
+	dec := json.NewDecoder(limitedBody)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondError(w, http.StatusBadRequest, "invalid skiddie payload")
+		return
+	}
+
+	version := strings.TrimSpace(req.Version)
+	if version != "" {
+		if err := core.ValidateLigoloVersion(version); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if err := core.SkiddieSupported(); err != nil {
+		respondError(w, skiddieErrorStatus(err), err.Error())
+		return
+	}
+
+	skiddieMu.Lock()
+	if skiddieRunning {
+		skiddieMu.Unlock()
+		respondError(w, http.StatusConflict, "skiddie install already running")
+		return
+	}
+	ctx, cancel := context.WithCancel(appCtx)
+	skiddieRunning = true
+	skiddieCancel = cancel
+	skiddieEvents = nil
+	skiddieJob++
+	job := skiddieJob
+	skiddieMu.Unlock()
+
+	go func() {
+		defer cancel()
+		result, err := core.RunSkiddieInstall(ctx, version, func(p core.SkiddieProgress) {
+			publishSkiddieEvent(skiddieEvent{Job: job, Type: "progress", Progress: &p})
+		})
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				err = errors.New("install cancelled; run again to resume")
+			}
+			publishSkiddieEvent(skiddieEvent{Job: job, Type: "error", Error: err.Error()})
+			return
+		}
+		published, err := core.AutoPublishAgents()
+		if err != nil {
+			result.Message += " Publishing agents to the file server failed: " + err.Error()
+		}
+		result.Published = published
+		publishSkiddieEvent(skiddieEvent{Job: job, Type: "result", Result: &result})
+	}()
+
+	respondJSON(w, http.StatusAccepted, map[string]interface{}{"status": "started", "job": job})
+}
+
+func handleSkiddieCancel(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
@@ -134,317 +613,1152 @@ func handleStopProxy(w http.ResponseWriter, r *http.Request) {
 	limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
 	defer limitedBody.Close()
 
-	proxyMu.Lock()
-	defer proxyMu.Unlock()
+	skiddieMu.Lock()
+	defer skiddieMu.Unlock()
+
+	if skiddieCancel == nil {
+		respondJSON(w, http.StatusOK, map[string]string{"status": "not_running"})
+		return
+	}
+	skiddieCancel()
+	respondJSON(w, http.StatusOK, map[string]string{"status": "cancelling"})
+}
+
+// handleSkiddieEvents streams the current (or last) job's events as
+// Server-Sent Events, replaying earlier events first.
+func handleSkiddieEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	skiddieMu.Lock()
+	job := skiddieJob
+	skiddieMu.Unlock()
+
+	sent := 0
+	for {
+		skiddieMu.Lock()
+		if skiddieJob != job {
+			// A new job replaced the one this stream was following.
+			skiddieMu.Unlock()
+			return
+		}
+		pending := append([]skiddieEvent(nil), skiddieEvents[sent:]...)
+		running := skiddieRunning
+		notify := skiddieNotify
+		skiddieMu.Unlock()
+
+		for _, ev := range pending {
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		}
+		sent += len(pending)
+		flusher.Flush()
+
+		if !running {
+			return
+		}
+
+		select {
+		case <-notify:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func handleLigoloVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	releases, err := core.ListLigoloVersions()
+	resp := map[string]interface{}{"releases": releases}
+	if err != nil {
+		if releases == nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp["warning"] = "remote release list unavailable: " + err.Error()
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+func handleLigoloSwitch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
+	defer limitedBody.Close()
+
+	var req struct {
+		Version string `json:"version"`
+	}
+	dec := json.NewDecoder(limitedBody)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid switch payload")
+		return
+	}
+
+	if err := core.SwitchLigoloVersion(strings.TrimSpace(req.Version)); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := map[string]interface{}{"status": "ok", "version": req.Version}
+	published, err := core.AutoPublishAgents()
+	if err != nil {
+		resp["publish_error"] = err.Error()
+	}
+	if published != nil {
+		resp["published"] = published
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// skiddieErrorStatus maps an install error to 400 when it is caused by the
+// host or the release files rather than by the server.
+func skiddieErrorStatus(err error) int {
+	for _, target := range []error{core.ErrSkiddieUnsupported, core.ErrChecksumMismatch, core.ErrInvalidArchive, core.ErrArchiveUnverified, core.ErrSmokeTest} {
+		if errors.Is(err, target) {
+			return http.StatusBadRequest
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+func handleSkiddieUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxArchiveUpload)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid upload")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["archives"]
+	if len(files) == 0 {
+		respondError(w, http.StatusBadRequest, "no archives uploaded")
+		return
+	}
+
+	// Cache everything first so an uploaded checksums.txt is available
+	// when the archives that accompany it are verified.
+	archives := []string{}
+	for _, fh := range files {
+		f, err := fh.Open()
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid upload")
+			return
+		}
+		path, err := core.ImportLigoloFile(fh.Filename, f)
+		f.Close()
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !strings.HasSuffix(path, "_checksums.txt") {
+			archives = append(archives, path)
+		}
+	}
+
+	allowUnverified := r.FormValue("allow_unverified") == "true"
+	results := []core.SkiddieResult{}
+	for _, path := range archives {
+		result, err := core.InstallLigoloArchive(path, allowUnverified)
+		if err != nil {
+			respondError(w, skiddieErrorStatus(err), err.Error())
+			return
+		}
+		results = append(results, result)
+	}
+
+	respondJSON(w, http.StatusOK, results)
+}
+
+func handleFileConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cfg, err := core.LoadConfig()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			respondError(w, http.StatusInternalServerError, "failed to load config")
+			return
+		}
+		cfg = core.SanitizeConfig(cfg)
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"file_bind":      cfg.FileBind,
+			"file_port":      cfg.FilePort,
+			"file_directory": cfg.FileDirectory,
+
+			"file_upload":        cfg.FileUpload,
+			"file_upload_token":  cfg.FileUploadToken,
+			"file_upload_max_mb": cfg.FileUploadMaxMB,
+
+			"file_tls":              cfg.FileTLS,
+			"file_cert_file":        cfg.FileCertFile,
+			"file_key_file":         cfg.FileKeyFile,
+			"file_cert_fingerprint": core.FileServerCertFingerprint(cfg),
+
+			"file_links_only":         cfg.FileLinksOnly,
+			"file_link_ttl_minutes":   cfg.FileLinkTTLMinutes,
+			"file_link_max_downloads": cfg.FileLinkMaxDownloads,
+		})
+	case http.MethodPost:
+		limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
+		defer limitedBody.Close()
+
+		dec := json.NewDecoder(limitedBody)
+		dec.DisallowUnknownFields()
+
+		type fileCfg struct {
+			FileBind      string `json:"file_bind"`
+			FilePort      int    `json:"file_port"`
+			FileDirectory string `json:"file_directory"`
+
+			// Upload settings are left alone when omitted. An empty token
+			// makes a new one be generated.
+			FileUpload      *bool   `json:"file_upload"`
+			FileUploadToken *string `json:"file_upload_token"`
+			FileUploadMaxMB *int    `json:"file_upload_max_mb"`
+
+			FileTLS      *bool   `json:"file_tls"`
+			FileCertFile *string `json:"file_cert_file"`
+			FileKeyFile  *string `json:"file_key_file"`
+
+			FileLinksOnly        *bool `json:"file_links_only"`
+			FileLinkTTLMinutes   *int  `json:"file_link_ttl_minutes"`
+			FileLinkMaxDownloads *int  `json:"file_link_max_downloads"`
+		}
+		var incoming fileCfg
+		if err := dec.Decode(&incoming); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid file config payload")
+			return
+		}
+
+		cfg, err := core.LoadConfig()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			respondError(w, http.StatusInternalServerError, "failed to load config")
+			return
+		}
+
+		cfg.FileBind = incoming.FileBind
+		cfg.FilePort = incoming.FilePort
+		cfg.FileDirectory = incoming.FileDirectory
+		fields := map[string]bool{"file_bind": true, "file_port": true, "file_directory": true}
+		if incoming.FileUpload != nil {
+			cfg.FileUpload = *incoming.FileUpload
+			fields["file_upload"] = true
+		}
+		if incoming.FileUploadToken != nil {
+			cfg.FileUploadToken = *incoming.FileUploadToken
+			fields["file_upload_token"] = true
+		}
+		if incoming.FileUploadMaxMB != nil {
+			cfg.FileUploadMaxMB = *incoming.FileUploadMaxMB
+			fields["file_upload_max_mb"] = true
+		}
+		if incoming.FileTLS != nil {
+			cfg.FileTLS = *incoming.FileTLS
+			fields["file_tls"] = true
+		}
+		if incoming.FileCertFile != nil {
+			cfg.FileCertFile = *incoming.FileCertFile
+			fields["file_cert_file"] = true
+		}
+		if incoming.FileKeyFile != nil {
+			cfg.FileKeyFile = *incoming.FileKeyFile
+			fields["file_key_file"] = true
+		}
+		if incoming.FileLinksOnly != nil {
+			cfg.FileLinksOnly = *incoming.FileLinksOnly
+			fields["file_links_only"] = true
+		}
+		if incoming.FileLinkTTLMinutes != nil {
+			cfg.FileLinkTTLMinutes = *incoming.FileLinkTTLMinutes
+			fields["file_link_ttl_minutes"] = true
+		}
+		if incoming.FileLinkMaxDownloads != nil {
+			cfg.FileLinkMaxDownloads = *incoming.FileLinkMaxDownloads
+			fields["file_link_max_downloads"] = true
+		}
+		errs := append(core.OverrideConflicts(cfg), core.ValidateConfig(cfg).Only(fields)...)
+		if len(errs) > 0 {
+			respondFieldErrors(w, errs)
+			return
+		}
+		cfg = core.SanitizeConfig(cfg)
+
+		if err := core.SaveConfig(cfg); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to save config")
+			return
+		}
+		if _, err := core.EnsureUploadToken(cfg); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to save upload token")
+			return
+		}
+
+		respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func handleFileStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
+	defer limitedBody.Close()
+
+	fileSrvMu.Lock()
+	defer fileSrvMu.Unlock()
+
+	if fileSrv != nil {
+		respondError(w, http.StatusConflict, "file server already running")
+		return
+	}
+
+	cfg, err := core.LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		respondError(w, http.StatusInternalServerError, "failed to load config")
+		return
+	}
+
+	srv, ln, err := newFileServer(cfg)
+	if errors.Is(err, errInvalidFileDirectory) {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to start file server: "+err.Error())
+		return
+	}
+
+	fileSrv = srv
+
+	go func(s *http.Server, l net.Listener) {
+		if err := s.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("file server error: %v", err)
+		}
+	}(srv, ln)
+
+	resp := map[string]string{"status": "started"}
+	if fp := core.FileServerCertFingerprint(core.SanitizeConfig(cfg)); fp != "" {
+		resp["fingerprint"] = fp
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+var errInvalidFileDirectory = errors.New("invalid file directory")
+
+// newFileServer builds the file server for cfg and opens its listener. It is
+// shared by the UI and the "files serve" command.
+func newFileServer(cfg core.Config) (*http.Server, net.Listener, error) {
+	cfg = core.SanitizeConfig(cfg)
+	if cfg.FileDirectory == "" {
+		return nil, nil, errInvalidFileDirectory
+	}
+	info, err := os.Stat(cfg.FileDirectory)
+	if err != nil || !info.IsDir() {
+		return nil, nil, errInvalidFileDirectory
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/d/", fileLinkHandler(cfg.FileDirectory))
+	if cfg.FileLinksOnly {
+		mux.Handle("/", http.NotFoundHandler())
+	} else {
+		mux.Handle("/", hideUploads(http.FileServer(core.FileServerFS(cfg.FileDirectory))))
+	}
+	if cfg.FileUpload {
+		if cfg, err = core.EnsureUploadToken(cfg); err != nil {
+			return nil, nil, err
+		}
+		mux.Handle("/upload/", fileUploadHandler(cfg))
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.FileTLS {
+		certFile, keyFile, err := core.FileServerCertFiles(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("file server certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	accessPath, err := core.FileAccessLogPath()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := fileAccess.Open(accessPath); err != nil {
+		return nil, nil, err
+	}
+
+	addr := fmt.Sprintf("%s:%d", cfg.FileBind, cfg.FilePort)
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      fileAccess.Handler(mux),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fileAccess.Close()
+		return nil, nil, err
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	return srv, ln, nil
+}
+
+// hideUploads keeps files uploaded from targets out of the public file
+// server.
+func hideUploads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := path.Clean("/" + r.URL.Path)
+		if p == "/"+core.UploadsDirName || strings.HasPrefix(p, "/"+core.UploadsDirName+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fileLinkHandler serves tokenized download links, /d/<token>/<name>. Each
+// download counts once, however many requests it takes; unknown, used up,
+// expired and source-restricted links all get the same 404.
+func fileLinkHandler(root string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/d/"), "/")
+		_, full, err := core.ClaimFileLink(root, token, r)
+		if errors.Is(err, core.ErrFileLinkInvalid) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("file server link %s: %v", token, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		f, err := os.Open(full)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil || fi.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+	})
+}
+
+// uploadToken returns the token sent with an upload: a Bearer token, the
+// Basic auth password or the token query parameter.
+func uploadToken(r *http.Request) string {
+	if _, pass, ok := r.BasicAuth(); ok {
+		return pass
+	}
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return r.URL.Query().Get("token")
+}
+
+// fileUploadHandler saves uploads from targets: a PUT or POST of the raw body
+// to /upload/<name>, or a multipart POST whose first file part is kept.
+// ?decode=certutil accepts certutil -encode output.
+func fileUploadHandler(cfg core.Config) http.Handler {
+	limit := int64(cfg.FileUploadMaxMB) << 20
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !core.CheckUploadToken(cfg, uploadToken(r)) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="upload"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/upload/")
+		decode := r.URL.Query().Get("decode")
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		multipart := mediaType == "multipart/form-data"
+		if !multipart && decode == "" && r.ContentLength > limit {
+			http.Error(w, core.ErrUploadTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// Large uploads outlast the server's read and write timeouts.
+		rc := http.NewResponseController(w)
+		deadline := time.Now().Add(30 * time.Minute)
+		_ = rc.SetReadDeadline(deadline)
+		_ = rc.SetWriteDeadline(deadline)
+
+		var body io.Reader = r.Body
+		if multipart {
+			mr, err := r.MultipartReader()
+			if err != nil {
+				http.Error(w, "invalid multipart upload", http.StatusBadRequest)
+				return
+			}
+			for {
+				part, err := mr.NextPart()
+				if err != nil {
+					http.Error(w, "no file in upload", http.StatusBadRequest)
+					return
+				}
+				if part.FileName() != "" {
+					if name == "" {
+						name = part.FileName()
+					}
+					body = part
+					break
+				}
+			}
+		}
+
+		source, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			source = r.RemoteAddr
+		}
+		up, err := core.SaveUpload(cfg, source, name, body, decode)
+		switch {
+		case errors.Is(err, core.ErrUploadTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		case errors.Is(err, core.ErrInvalidUpload):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			log.Printf("file server upload from %s failed: %v", source, err)
+			http.Error(w, "upload failed", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("file server: saved upload %s from %s (%d bytes)", up.Path, source, up.Size)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "saved %s (%d bytes, sha256 %s)\n", up.Name, up.Size, up.SHA256)
+	})
+}
+
+func handleFileStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
+	defer limitedBody.Close()
+
+	if !stopFileServer() {
+		respondJSON(w, http.StatusOK, map[string]string{"status": "not_running"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"status": "stopped"})
+}
+
+// stopFileServer shuts down the file server, if running, and reports whether
+// it was.
+func stopFileServer() bool {
+	fileSrvMu.Lock()
+	defer fileSrvMu.Unlock()
+
+	if fileSrv == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := fileSrv.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("file server shutdown error: %v", err)
+	}
+	fileSrv = nil
+	fileAccess.Close()
+	return true
+}
+
+func handleFileStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	fileSrvMu.Lock()
+	running := fileSrv != nil
+	fileSrvMu.Unlock()
+
+	respondJSON(w, http.StatusOK, map[string]bool{"file_server_running": running})
+}
+
+func handleFileCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	osParam := r.URL.Query().Get("os")
+	filename := strings.TrimSpace(r.URL.Query().Get("filename"))
+	if err := core.ValidateFileCommand(osParam, filename); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	link, err := fileLinkOptions(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cfg, err := core.LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		respondError(w, http.StatusInternalServerError, "failed to load config")
+		return
+	}
+	cmd, err := core.FileDownloadCommand(cfg, osParam, filename, link)
+	if errors.Is(err, core.ErrOutsideFileRoot) {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, cmd)
+}
+
+// fileLinkOptions reads the link limits of a file command request: link=1
+// (or any of ttl_minutes, max_downloads, source_ip) asks for a tokenized
+// link. It returns nil for a plain URL.
+func fileLinkOptions(q url.Values) (*core.FileLinkOptions, error) {
+	ttl, maxDownloads, source := q.Get("ttl_minutes"), q.Get("max_downloads"), strings.TrimSpace(q.Get("source_ip"))
+	wantLink, _ := strconv.ParseBool(q.Get("link"))
+	if !wantLink && ttl == "" && maxDownloads == "" && source == "" {
+		return nil, nil
+	}
+	opts := &core.FileLinkOptions{SourceIP: source}
+	if ttl != "" {
+		n, err := strconv.Atoi(ttl)
+		if err != nil || n < 0 {
+			return nil, errors.New("invalid ttl_minutes")
+		}
+		opts.TTL = time.Duration(n) * time.Minute
+	}
+	if maxDownloads != "" {
+		n, err := strconv.Atoi(maxDownloads)
+		if err != nil || n < 0 {
+			return nil, errors.New("invalid max_downloads")
+		}
+		opts.MaxDownloads = n
+	}
+	if err := core.ValidateSourceIP(source); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// handleFileLinks lists download links (GET) or creates and revokes them
+// (POST {action, path, ttl_minutes, max_downloads, source_ip, token}).
+func handleFileLinks(w http.ResponseWriter, r *http.Request) {
+	cfg, err := core.LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		respondError(w, http.StatusInternalServerError, "failed to load config")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		links, err := core.ListFileLinks(cfg)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to read links")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"links": fileLinkViews(cfg, links)})
+	case http.MethodPost:
+		limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
+		defer limitedBody.Close()
+
+		var req struct {
+			Action       string `json:"action"`
+			Path         string `json:"path"`
+			TTLMinutes   int    `json:"ttl_minutes"`
+			MaxDownloads int    `json:"max_downloads"`
+			SourceIP     string `json:"source_ip"`
+			Token        string `json:"token"`
+		}
+		dec := json.NewDecoder(limitedBody)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid link payload")
+			return
+		}
+
+		switch req.Action {
+		case "create":
+			if req.TTLMinutes < 0 || req.MaxDownloads < 0 {
+				respondError(w, http.StatusBadRequest, "ttl_minutes and max_downloads must not be negative")
+				return
+			}
+			if err := core.ValidateSourceIP(req.SourceIP); err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			link, err := core.CreateFileLink(cfg, req.Path, core.FileLinkOptions{
+				TTL:          time.Duration(req.TTLMinutes) * time.Minute,
+				MaxDownloads: req.MaxDownloads,
+				SourceIP:     req.SourceIP,
+			})
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, fileLinkViews(cfg, []core.FileLink{link})[0])
+		case "revoke":
+			if err := core.RevokeFileLink(req.Token); err != nil {
+				respondError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+		default:
+			respondError(w, http.StatusBadRequest, "unknown action")
+		}
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// fileLinkView is a link with its URL and whether it can still be used.
+type fileLinkView struct {
+	core.FileLink
+	URL    string `json:"url"`
+	Active bool   `json:"active"`
+}
 
-	if proxyCmd == nil {
-		respondJSON(w, http.StatusOK, map[string]string{"status": "not_running"})
-		return
+func fileLinkViews(cfg core.Config, links []core.FileLink) []fileLinkView {
+	// Without a resolvable public IP the URL keeps the placeholder.
+	if resolved, err := core.ResolveCallbackConfig(core.SanitizeConfig(cfg)); err == nil {
+		cfg = resolved
 	}
-
-	if proxyCmd.Process != nil {
-		_ = proxyCmd.Process.Kill()
+	now := time.Now()
+	views := make([]fileLinkView, 0, len(links))
+	for _, l := range links {
+		views = append(views, fileLinkView{FileLink: l, URL: core.FileLinkURL(cfg, l), Active: l.Active(now)})
 	}
-	go func(cmd *exec.Cmd) {
-		_ = cmd.Wait()
-	}(proxyCmd)
+	return views
+}
 
-	proxyCmd = nil
-	respondJSON(w, http.StatusOK, map[string]string{"status": "stopped"})
+// fileAccessEvent is a file server request plus its one-line summary for the
+// console.
+type fileAccessEvent struct {
+	core.FileAccess
+	Summary string `json:"summary"`
 }
 
-func handleStatus(w http.ResponseWriter, r *http.Request) {
+func fileAccessEvents(entries []core.FileAccess) []fileAccessEvent {
+	events := make([]fileAccessEvent, 0, len(entries))
+	for _, a := range entries {
+		events = append(events, fileAccessEvent{FileAccess: a, Summary: a.Summary()})
+	}
+	return events
+}
+
+// handleFileAccess returns the most recent file server requests (GET
+// ?lines=N, default 100).
+func handleFileAccess(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
-	proxyMu.Lock()
-	running := proxyCmd != nil
-	proxyMu.Unlock()
+	n, err := strconv.Atoi(r.URL.Query().Get("lines"))
+	if err != nil || n <= 0 {
+		n = 100
+	}
+	logFile := fileAccess.Path()
+	if logFile == "" {
+		logFile, _ = core.FileAccessLogPath()
+	}
 
-	respondJSON(w, http.StatusOK, map[string]bool{"proxy_running": running})
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"log_file": logFile,
+		"entries":  fileAccessEvents(fileAccess.Tail(n)),
+	})
 }
 
-func handleAgent(w http.ResponseWriter, r *http.Request) {
+// handleFileAccessStream live-tails file server requests as Server-Sent
+// Events, resuming from Last-Event-ID like the proxy log stream.
+func handleFileAccessStream(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
-	osParam := r.URL.Query().Get("os")
-	if osParam != "linux" && osParam != "windows" {
-		respondError(w, http.StatusBadRequest, "invalid os")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, http.StatusInternalServerError, "streaming unsupported")
 		return
 	}
 
-	cfg, err := core.LoadConfig()
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			cfg = core.DefaultConfig()
-		} else {
-			respondError(w, http.StatusInternalServerError, "failed to load config")
-			return
-		}
+	since := r.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = r.URL.Query().Get("since")
 	}
+	seq, _ := strconv.ParseUint(since, 10, 64)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		entries, notify := fileAccess.Since(seq)
+		for _, ev := range fileAccessEvents(entries) {
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "id: %d\nevent: access\ndata: %s\n\n", ev.Seq, data)
+			seq = ev.Seq
+		}
+		flusher.Flush()
 
-	cfg = core.SanitizeConfig(cfg)
-
-	var cmd string
-	if osParam == "linux" {
-		cmd = core.AgentCmdLinux(cfg)
-	} else {
-		cmd = core.AgentCmdWindows(cfg)
+		select {
+		case <-notify:
+		case <-r.Context().Done():
+			return
+		}
 	}
-
-	respondJSON(w, http.StatusOK, map[string]string{"command": cmd})
 }
 
-func handleSkiddie(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+// handleFileUploadCommand returns upload one-liners for a file on the
+// target (GET ?filename=).
+func handleFileUploadCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
-	limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
-	defer limitedBody.Close()
+	filename := strings.TrimSpace(r.URL.Query().Get("filename"))
+	if filename == "" {
+		respondError(w, http.StatusBadRequest, "filename is required")
+		return
+	}
 
-	result, err := core.RunSkiddieInstall()
+	cfg, err := core.LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		respondError(w, http.StatusInternalServerError, "failed to load config")
+		return
+	}
+	if cfg, err = core.EnsureUploadToken(cfg); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to save upload token")
+		return
+	}
+	cmds, err := core.FileUploadCommands(cfg, filename)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if strings.Contains(strings.ToLower(err.Error()), "linux only") {
-			status = http.StatusBadRequest
-		}
-		respondError(w, status, err.Error())
+		respondError(w, http.StatusConflict, err.Error())
 		return
 	}
 
-	respondJSON(w, http.StatusOK, result)
+	respondJSON(w, http.StatusOK, map[string]interface{}{"commands": cmds})
 }
 
-func handleFileConfig(w http.ResponseWriter, r *http.Request) {
+// handleWorkspaces lists workspaces (GET) or creates, switches, archives and
+// restores them (POST {action, name}).
+func handleWorkspaces(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		cfg, err := core.LoadConfig()
+		list, err := core.ListWorkspaces()
 		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				cfg = core.DefaultConfig()
-			} else {
-				respondError(w, http.StatusInternalServerError, "failed to load config")
-				return
-			}
+			respondError(w, http.StatusInternalServerError, "failed to list workspaces: "+err.Error())
+			return
 		}
-		cfg = core.SanitizeConfig(cfg)
 		respondJSON(w, http.StatusOK, map[string]interface{}{
-			"file_bind":      cfg.FileBind,
-			"file_port":      cfg.FilePort,
-			"file_directory": cfg.FileDirectory,
+			"active":     core.ActiveWorkspace(),
+			"workspaces": list,
 		})
 	case http.MethodPost:
 		limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
 		defer limitedBody.Close()
 
+		var req struct {
+			Action string `json:"action"`
+			Name   string `json:"name"`
+		}
 		dec := json.NewDecoder(limitedBody)
 		dec.DisallowUnknownFields()
-
-		type fileCfg struct {
-			FileBind      string `json:"file_bind"`
-			FilePort      int    `json:"file_port"`
-			FileDirectory string `json:"file_directory"`
-		}
-		var incoming fileCfg
-		if err := dec.Decode(&incoming); err != nil {
-			respondError(w, http.StatusBadRequest, "invalid file config payload")
+		if err := dec.Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid workspace payload")
 			return
 		}
-
-		cfg, err := core.LoadConfig()
-		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				cfg = core.DefaultConfig()
-			} else {
-				respondError(w, http.StatusInternalServerError, "failed to load config")
+		name := strings.TrimSpace(req.Name)
+
+		var err error
+		switch req.Action {
+		case "create":
+			_, err = core.CreateWorkspace(name)
+		case "switch":
+			// Running proxies and the file server belong to the current
+			// workspace's config, so they must be stopped first.
+			fileSrvMu.Lock()
+			fileRunning := fileSrv != nil
+			fileSrvMu.Unlock()
+			if running := proxies.Running(); len(running) > 0 || fileRunning {
+				respondError(w, http.StatusConflict, "stop the proxy and file server before switching workspaces")
 				return
 			}
+			err = core.SwitchWorkspace(name)
+		case "archive":
+			err = core.ArchiveWorkspace(name, true)
+		case "unarchive":
+			err = core.ArchiveWorkspace(name, false)
+		default:
+			respondError(w, http.StatusBadRequest, "action must be create, switch, archive or unarchive")
+			return
 		}
-
-		cfg.FileBind = incoming.FileBind
-		cfg.FilePort = incoming.FilePort
-		cfg.FileDirectory = incoming.FileDirectory
-		cfg = core.SanitizeConfig(cfg)
-
-		if err := core.SaveConfig(cfg); err != nil {
-			respondError(w, http.StatusInternalServerError, "failed to save config")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-
-		respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		respondJSON(w, http.StatusOK, map[string]string{"status": "ok", "active": core.ActiveWorkspace()})
 	default:
 		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
-func handleFileStart(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+// handleInterfaces lists local interfaces and how the configured public IP
+// currently resolves.
+func handleInterfaces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
-	limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
-	defer limitedBody.Close()
-
-	fileSrvMu.Lock()
-	defer fileSrvMu.Unlock()
-
-	if fileSrv != nil {
-		respondError(w, http.StatusConflict, "file server already running")
+	ifaces, err := core.ListInterfaces()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to list interfaces: "+err.Error())
 		return
 	}
 
 	cfg, err := core.LoadConfig()
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			cfg = core.DefaultConfig()
-		} else {
-			respondError(w, http.StatusInternalServerError, "failed to load config")
-			return
-		}
-	}
-	cfg = core.SanitizeConfig(cfg)
-
-	if cfg.FileDirectory == "" {
-		respondError(w, http.StatusBadRequest, "invalid file directory")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		respondError(w, http.StatusInternalServerError, "failed to load config")
 		return
 	}
-	info, statErr := os.Stat(cfg.FileDirectory)
-	if statErr != nil || !info.IsDir() {
-		respondError(w, http.StatusBadRequest, "invalid file directory")
-		return
+	resp := map[string]interface{}{"interfaces": ifaces, "public_ip": cfg.PublicIP}
+	if resolved, err := core.ResolvePublicIP(cfg); err != nil {
+		resp["resolve_error"] = err.Error()
+	} else {
+		resp["resolved"] = resolved
 	}
+	respondJSON(w, http.StatusOK, resp)
+}
 
-	addr := fmt.Sprintf("%s:%d", cfg.FileBind, cfg.FilePort)
-	fs := http.FileServer(http.Dir(cfg.FileDirectory))
-
-	srv := &http.Server{
-		Addr:         addr,
-		Handler:      fs,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  60 * time.Second,
+func handleFileList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
 	}
 
-	ln, err := net.Listen("tcp", addr)
+	listing, err := core.ListFileServerDir(r.URL.Query().Get("path"))
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "failed to start file server")
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	fileSrv = srv
+	respondJSON(w, http.StatusOK, listing)
+}
 
-	go func(s *http.Server, l net.Listener) {
-		if err := s.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Printf("file server error: %v", err)
-		}
-	}(srv, ln)
+// ligoloAPIFor returns a client for the proxy API, reusing the cached one
+// (and its session token) while the API settings are unchanged.
+func ligoloAPIFor(cfg core.Config) (*core.LigoloAPIClient, error) {
+	key := cfg.ProxyAPIAddr + "\x00" + cfg.ProxyAPIUser + "\x00" + cfg.ProxyAPIPassword
 
-	respondJSON(w, http.StatusOK, map[string]string{"status": "started"})
+	ligoloAPIMu.Lock()
+	defer ligoloAPIMu.Unlock()
+	if ligoloAPI != nil && ligoloAPIKey == key {
+		return ligoloAPI, nil
+	}
+	client, err := core.LigoloAPIFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ligoloAPI, ligoloAPIKey = client, key
+	return client, nil
 }
 
-func handleFileStop(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
+// ligoloAPIClient loads config and returns an API client, writing an error
+// response and returning nil when the API can't be used.
+func ligoloAPIClient(w http.ResponseWriter) *core.LigoloAPIClient {
+	cfg, err := core.LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		respondError(w, http.StatusInternalServerError, "failed to load config")
+		return nil
 	}
-
-	limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
-	defer limitedBody.Close()
-
-	fileSrvMu.Lock()
-	defer fileSrvMu.Unlock()
-
-	if fileSrv == nil {
-		respondJSON(w, http.StatusOK, map[string]string{"status": "not_running"})
-		return
+	client, err := ligoloAPIFor(core.SanitizeConfig(cfg))
+	if err != nil {
+		respondError(w, http.StatusConflict, err.Error())
+		return nil
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	if err := fileSrv.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Printf("file server shutdown error: %v", err)
+	if sup, err := proxies.Supervisor(core.DefaultProxyInstance); err != nil || !sup.Status().Running() {
+		respondError(w, http.StatusConflict, "proxy is not running")
+		return nil
 	}
-	fileSrv = nil
-
-	respondJSON(w, http.StatusOK, map[string]string{"status": "stopped"})
+	return client
 }
 
-func handleFileStatus(w http.ResponseWriter, r *http.Request) {
+func handleLigoloAgents(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
-	fileSrvMu.Lock()
-	running := fileSrv != nil
-	fileSrvMu.Unlock()
-
-	respondJSON(w, http.StatusOK, map[string]bool{"file_server_running": running})
+	client := ligoloAPIClient(w)
+	if client == nil {
+		return
+	}
+	agents, err := client.Agents(r.Context())
+	if err != nil {
+		respondError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"agents": agents})
 }
 
-func handleFileCommand(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+func handleLigoloTunnel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
-	osParam := r.URL.Query().Get("os")
-	if osParam != "linux" && osParam != "windows" {
-		respondError(w, http.StatusBadRequest, "invalid os")
+	limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
+	defer limitedBody.Close()
+
+	var req struct {
+		Action    string `json:"action"`
+		AgentID   int    `json:"agent_id"`
+		Interface string `json:"interface"`
+	}
+	dec := json.NewDecoder(limitedBody)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid tunnel payload")
 		return
 	}
 
-	filename := strings.TrimSpace(r.URL.Query().Get("filename"))
-	if filename == "" || strings.Contains(filename, "/") || strings.Contains(filename, "\\") || strings.Contains(filename, "..") {
-		respondError(w, http.StatusBadRequest, "invalid filename")
+	client := ligoloAPIClient(w)
+	if client == nil {
 		return
 	}
 
-	cfg, err := core.LoadConfig()
+	var err error
+	switch req.Action {
+	case "start":
+		err = client.StartTunnel(r.Context(), req.AgentID, strings.TrimSpace(req.Interface))
+	case "stop":
+		err = client.StopTunnel(r.Context(), req.AgentID)
+	default:
+		respondError(w, http.StatusBadRequest, "action must be start or stop")
+		return
+	}
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			cfg = core.DefaultConfig()
-		} else {
-			respondError(w, http.StatusInternalServerError, "failed to load config")
-			return
-		}
+		respondError(w, http.StatusBadGateway, err.Error())
+		return
 	}
-	cfg = core.SanitizeConfig(cfg)
-
-	url := fmt.Sprintf("http://%s:%d/%s", cfg.PublicIP, cfg.FilePort, filename)
+	respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
 
-	var cmd string
-	if osParam == "linux" {
-		cmd = fmt.Sprintf("curl -o %s %s", filename, url)
-	} else {
-		cmd = fmt.Sprintf(`powershell -Command "Invoke-WebRequest -Uri '%s' -OutFile '%s'"`, url, filename)
-	}
+func handleLigoloListeners(w http.ResponseWriter, r *http.Request) {
+	var client *core.LigoloAPIClient
+	switch r.Method {
+	case http.MethodGet:
+		if client = ligoloAPIClient(w); client == nil {
+			return
+		}
+		listeners, err := client.Listeners(r.Context())
+		if err != nil {
+			respondError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"listeners": listeners})
+	case http.MethodPost:
+		limitedBody := http.MaxBytesReader(w, r.Body, maxRequestBody)
+		defer limitedBody.Close()
 
-	respondJSON(w, http.StatusOK, map[string]string{"command": cmd})
-}
+		var req struct {
+			Action       string `json:"action"`
+			AgentID      int    `json:"agent_id"`
+			ListenerID   int    `json:"listener_id"`
+			Network      string `json:"network"`
+			ListenerAddr string `json:"listener_addr"`
+			RedirectAddr string `json:"redirect_addr"`
+		}
+		dec := json.NewDecoder(limitedBody)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid listener payload")
+			return
+		}
+		if client = ligoloAPIClient(w); client == nil {
+			return
+		}
 
-func handleFileList(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+		var err error
+		switch req.Action {
+		case "add":
+			err = client.AddListener(r.Context(), req.AgentID, req.Network, strings.TrimSpace(req.ListenerAddr), strings.TrimSpace(req.RedirectAddr))
+		case "remove":
+			err = client.RemoveListener(r.Context(), req.AgentID, req.ListenerID)
+		default:
+			respondError(w, http.StatusBadRequest, "action must be add or remove")
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	default:
 		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
-	}
-
-	entries, err := core.ListFileServerDir()
-	if err != nil {
-		respondError(w, http.StatusBadRequest, err.Error())
-		return
 	}
-
-	respondJSON(w, http.StatusOK, entries)
 }
 
 func handleFSScout(w http.ResponseWriter, r *http.Request) {
@@ -464,7 +1778,13 @@ func handleFSScout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res, err := core.RunFSScout(req)
+	// Scouts stop when the client goes away or the UI shuts down.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stop := context.AfterFunc(appCtx, cancel)
+	defer stop()
+
+	res, err := core.RunFSScout(ctx, req)
 	if err != nil {
 		respondJSON(w, http.StatusBadRequest, res)
 		return
@@ -473,7 +1793,70 @@ func handleFSScout(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, res)
 }
 
+// configFlag records a Config field given on the command line.
+type configFlag struct {
+	key    string
+	bool   bool
+	values map[string]string
+}
+
+func (f *configFlag) String() string   { return "" }
+func (f *configFlag) IsBoolFlag() bool { return f.bool }
+func (f *configFlag) Set(value string) error {
+	f.values[f.key] = value
+	return nil
+}
+
+// parseFlags parses the command line and registers the config overrides.
+// Precedence is flag > POTG_* environment variable > config file > default.
+// It returns the UI listen address.
+func parseFlags() (string, error) {
+	listen := flag.String("listen", "", "UI listen address (env POTG_LISTEN, default "+defaultListenAddr+")")
+	configPath := flag.String("config", "", "global config file (env POTG_CONFIG)")
+	dataDir := flag.String("data-dir", "", "app data directory (env POTG_DATA_DIR)")
+	values := map[string]string{}
+	for _, k := range core.ConfigKeys() {
+		flag.Var(&configFlag{key: k.Key, bool: k.Bool, values: values}, k.Flag, fmt.Sprintf("config %s (env %s)", k.Key, k.Env))
+	}
+	flag.Parse()
+
+	if err := core.SetFlagOverrides(values); err != nil {
+		return "", err
+	}
+	if err := core.CheckConfigOverrides(); err != nil {
+		return "", err
+	}
+	core.SetConfigPathOverride(*configPath)
+	core.SetAppDataDirOverride(*dataDir)
+
+	addr := *listen
+	if addr == "" {
+		addr = strings.TrimSpace(os.Getenv(core.EnvPrefix + "LISTEN"))
+	}
+	if addr == "" {
+		addr = defaultListenAddr
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", fmt.Errorf("listen address %q: %w", addr, err)
+	}
+	return addr, nil
+}
+
 func main() {
+	flag.Usage = func() { cliUsage(os.Stderr) }
+	listenAddr, err := parseFlags()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if flag.NArg() > 0 {
+		os.Exit(runCLI(flag.Args()))
+	}
+
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	appCtx = ctx
+
 	embedded, err := webassets.FS()
 	if err != nil {
 		log.Fatalf("failed to load embedded web assets: %v", err)
@@ -483,6 +1866,25 @@ func main() {
 		fmt.Fprintf(os.Stderr, "failed to initialize loot directory: %v\n", err)
 	}
 
+	logDir, err := core.ProxyLogDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to locate proxy log dir, keeping output in memory: %v\n", err)
+		logDir = ""
+	}
+	proxies = core.NewProxyRegistry(logDir)
+	defer proxies.Close()
+	if cfg, err := core.LoadConfig(); errors.Is(err, core.ErrConfigTooNew) {
+		log.Printf("%v; upgrade PivotOnTheGO to use it", err)
+	} else if err == nil || errors.Is(err, os.ErrNotExist) {
+		adopted, errs := proxies.AdoptAll(cfg)
+		for _, err := range errs {
+			log.Printf("not re-attaching to proxy %v", err)
+		}
+		for _, name := range adopted {
+			log.Printf("re-attached to running proxy %q", name)
+		}
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/config", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
@@ -495,18 +1897,37 @@ func main() {
 		}
 		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 	})
+	mux.HandleFunc("/api/proxies", handleProxies)
 	mux.HandleFunc("/api/start-proxy", handleStartProxy)
 	mux.HandleFunc("/api/stop-proxy", handleStopProxy)
 	mux.HandleFunc("/api/status", handleStatus)
+	mux.HandleFunc("/api/proxy-log", handleProxyLog)
+	mux.HandleFunc("/api/proxy-log-stream", handleProxyLogStream)
+	mux.HandleFunc("/api/ligolo-agents", handleLigoloAgents)
+	mux.HandleFunc("/api/ligolo-tunnel", handleLigoloTunnel)
+	mux.HandleFunc("/api/ligolo-listeners", handleLigoloListeners)
 	mux.HandleFunc("/api/agent", handleAgent)
+	mux.HandleFunc("/api/agent-templates", handleAgentTemplates)
+	mux.HandleFunc("/api/agent-publish", handleAgentPublish)
+	mux.HandleFunc("/api/interfaces", handleInterfaces)
+	mux.HandleFunc("/api/workspaces", handleWorkspaces)
 	mux.HandleFunc("/api/file-config", handleFileConfig)
 	mux.HandleFunc("/api/file-start", handleFileStart)
 	mux.HandleFunc("/api/file-stop", handleFileStop)
 	mux.HandleFunc("/api/file-status", handleFileStatus)
 	mux.HandleFunc("/api/file-command", handleFileCommand)
+	mux.HandleFunc("/api/file-upload-command", handleFileUploadCommand)
 	mux.HandleFunc("/api/file-list", handleFileList)
+	mux.HandleFunc("/api/file-links", handleFileLinks)
+	mux.HandleFunc("/api/file-access", handleFileAccess)
+	mux.HandleFunc("/api/file-access-stream", handleFileAccessStream)
 	mux.HandleFunc("/api/fs-scout", handleFSScout)
 	mux.HandleFunc("/api/skiddie", handleSkiddie)
+	mux.HandleFunc("/api/skiddie-upload", handleSkiddieUpload)
+	mux.HandleFunc("/api/skiddie-cancel", handleSkiddieCancel)
+	mux.HandleFunc("/api/skiddie-events", handleSkiddieEvents)
+	mux.HandleFunc("/api/ligolo-versions", handleLigoloVersions)
+	mux.HandleFunc("/api/ligolo-switch", handleLigoloSwitch)
 
 	// Serve assets: prefer app data dir, fallback to embedded root.
 	assetDir := ""
@@ -522,13 +1943,43 @@ func main() {
 	mux.Handle("/", staticFS)
 
 	srv := &http.Server{
-		Addr:              "127.0.0.1:8080",
+		Addr:              listenAddr,
 		Handler:           mux,
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
-	log.Println("PivotOnTheGO UI listening on 127.0.0.1:8080")
-	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("server error: %v", err)
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("PivotOnTheGO UI listening on %s", listenAddr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("server error: %v", err)
+		}
+	case <-ctx.Done():
+		// A second Ctrl-C falls through to the default handler and exits.
+		stopSignals()
+		log.Println("shutting down")
+	}
+	shutdown(srv)
+}
+
+// shutdown stops every proxy (SIGTERM, then SIGKILL) and the file server, then
+// the UI server. Skiddie jobs and scouts are already cancelled via appCtx.
+func shutdown(srv *http.Server) {
+	for _, name := range proxies.StopAll() {
+		log.Printf("proxy %q stopped", name)
+	}
+	if stopFileServer() {
+		log.Println("file server stopped")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("UI server shutdown error: %v", err)
 	}
 }
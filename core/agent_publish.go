@@ -0,0 +1,247 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PublishedAgent is an agent build copied into the file server directory.
+type PublishedAgent struct {
+	Platform    string    `json:"platform"`
+	Name        string    `json:"name"` // file name in the file server directory
+	Random      bool      `json:"random"`
+	SHA256      string    `json:"sha256"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// agentPublishState records what was published where, so copies can be
+// found by platform and cleaned up on the next publish.
+type agentPublishState struct {
+	Dir     string           `json:"dir"`
+	Version string           `json:"version"`
+	Agents  []PublishedAgent `json:"agents"`
+}
+
+// PublishedAgentsPath returns the file recording the agent builds published
+// by the active workspace, next to its loot dir.
+func PublishedAgentsPath() (string, error) {
+	lootDir, err := DefaultLootDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(lootDir), "published_agents.json"), nil
+}
+
+func readAgentPublishState() (agentPublishState, error) {
+	var st agentPublishState
+	path, err := PublishedAgentsPath()
+	if err != nil {
+		return st, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(data, &st)
+	return st, err
+}
+
+func writeAgentPublishState(st agentPublishState) error {
+	path, err := PublishedAgentsPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o600)
+}
+
+// PublishedAgents returns the agent builds published into cfg.FileDirectory
+// that are still there.
+func PublishedAgents(cfg Config) []PublishedAgent {
+	cfg = SanitizeConfig(cfg)
+	st, err := readAgentPublishState()
+	if err != nil || st.Dir != cfg.FileDirectory {
+		return []PublishedAgent{}
+	}
+	agents := []PublishedAgent{}
+	for _, a := range st.Agents {
+		if _, err := os.Stat(filepath.Join(st.Dir, a.Name)); err == nil {
+			agents = append(agents, a)
+		}
+	}
+	return agents
+}
+
+// publishedAgentName returns the published file name for a platform, if any.
+func publishedAgentName(cfg Config, p LigoloPlatform) (string, bool) {
+	for _, a := range PublishedAgents(cfg) {
+		if a.Platform == p.String() {
+			return a.Name, true
+		}
+	}
+	return "", false
+}
+
+// PublishAgents copies every installed agent build of the active Ligolo
+// version into cfg.FileDirectory. With AgentPublishRandom the copies get
+// random names, which are kept across publishes unless rotate is set.
+// Copies from a previous publish into the same directory that are no longer
+// current are removed. Other files are never overwritten. If a platform
+// fails, what was published so far is still recorded.
+func PublishAgents(cfg Config, rotate bool) ([]PublishedAgent, error) {
+	cfg = SanitizeConfig(cfg)
+	if cfg.FileDirectory == "" {
+		return nil, errors.New("file server directory not configured")
+	}
+	installDir, err := LigoloInstallDir()
+	if err != nil {
+		return nil, err
+	}
+	activeDir := ligoloActiveDir(cfg, installDir)
+
+	prev, err := readAgentPublishState()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	prevNames := map[string]string{}
+	owned := map[string]bool{}
+	if prev.Dir == cfg.FileDirectory {
+		for _, a := range prev.Agents {
+			owned[a.Name] = true
+			if a.Random == cfg.AgentPublishRandom {
+				prevNames[a.Platform] = a.Name
+			}
+		}
+	}
+
+	st := agentPublishState{Dir: cfg.FileDirectory, Version: cfg.LigoloVersion, Agents: []PublishedAgent{}}
+	keep := map[string]bool{}
+	// fail records the new copies along with the previous ones not yet
+	// replaced, so all of them are still found and cleaned up later.
+	fail := func(err error) ([]PublishedAgent, error) {
+		if len(st.Agents) > 0 {
+			partial := st
+			for _, a := range prev.Agents {
+				if owned[a.Name] && !keep[a.Name] {
+					partial.Agents = append(partial.Agents, a)
+				}
+			}
+			_ = writeAgentPublishState(partial)
+		}
+		return st.Agents, err
+	}
+	for _, v := range agentVariants(cfg, activeDir) {
+		if !v.Present {
+			continue
+		}
+		p, err := ParseLigoloPlatform(v.Platform)
+		if err != nil {
+			continue
+		}
+
+		name := p.AgentFilename()
+		if cfg.AgentPublishRandom {
+			name = prevNames[v.Platform]
+			if name == "" || rotate {
+				if name, err = randomAgentName(p); err != nil {
+					return fail(err)
+				}
+			}
+		}
+
+		src := filepath.Join(activeDir, v.Filename)
+		sum, err := fileSHA256(src)
+		if err != nil {
+			return fail(err)
+		}
+		if !owned[name] {
+			if err := claimAgentName(cfg.FileDirectory, name); err != nil {
+				return fail(fmt.Errorf("publish %s: %w", v.Platform, err))
+			}
+		}
+		f, err := os.Open(src)
+		if err == nil {
+			err = writeExecutable(f, cfg.FileDirectory, name)
+			f.Close()
+		}
+		if err != nil {
+			if !owned[name] {
+				_ = os.Remove(filepath.Join(cfg.FileDirectory, name))
+			}
+			return fail(fmt.Errorf("publish %s: %w", v.Platform, err))
+		}
+
+		keep[name] = true
+		st.Agents = append(st.Agents, PublishedAgent{
+			Platform:    v.Platform,
+			Name:        name,
+			Random:      cfg.AgentPublishRandom,
+			SHA256:      sum,
+			PublishedAt: time.Now(),
+		})
+	}
+	if len(st.Agents) == 0 {
+		return st.Agents, errors.New("no agent builds installed (run Skiddie Mode first)")
+	}
+
+	// Copies left in another directory are not touched: it may be shared
+	// with, or belong to, something else by now.
+	if prev.Dir == st.Dir {
+		for _, a := range prev.Agents {
+			if !keep[a.Name] {
+				_ = os.Remove(filepath.Join(st.Dir, a.Name))
+			}
+		}
+	}
+	return st.Agents, writeAgentPublishState(st)
+}
+
+// AutoPublishAgents publishes the installed agents when Config.AgentPublish
+// is set and returns what was published (nil when disabled).
+func AutoPublishAgents() ([]PublishedAgent, error) {
+	cfg, err := LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if !cfg.AgentPublish {
+		return nil, nil
+	}
+	return PublishAgents(cfg, false)
+}
+
+// claimAgentName creates the file name with O_EXCL, so a publish never
+// replaces a file it didn't write.
+func claimAgentName(dir, name string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o755)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists in the file server directory", name)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func randomAgentName(p LigoloPlatform) (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	name := hex.EncodeToString(b)
+	if p.OS == "windows" {
+		name += ".exe"
+	}
+	return name, nil
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPublishAgentsPerWorkspace(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	installDir, err := LigoloInstallDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(installDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(installDir, "agent_linux_amd64"), "agent")
+
+	publish := func() (Config, []PublishedAgent) {
+		t.Helper()
+		cfg, err := LoadConfig()
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		cfg.AgentPlatforms = []string{"linux/amd64"}
+		cfg.AgentPublishRandom = true
+		agents, err := PublishAgents(cfg, false)
+		if err != nil || len(agents) != 1 {
+			t.Fatalf("PublishAgents = %v, %v", agents, err)
+		}
+		return SanitizeConfig(cfg), agents
+	}
+
+	defaultCfg, first := publish()
+	if _, err := CreateWorkspace("op2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SwitchWorkspace("op2"); err != nil {
+		t.Fatal(err)
+	}
+	op2Cfg, second := publish()
+	if op2Cfg.FileDirectory == defaultCfg.FileDirectory {
+		t.Fatal("workspaces share a file server directory")
+	}
+
+	// Publishing in op2 leaves the default workspace's copy alone.
+	if _, err := os.Stat(filepath.Join(defaultCfg.FileDirectory, first[0].Name)); err != nil {
+		t.Errorf("agent published by the default workspace was removed: %v", err)
+	}
+	if got := PublishedAgents(op2Cfg); len(got) != 1 || got[0].Name != second[0].Name {
+		t.Errorf("op2 published agents = %v", got)
+	}
+
+	if err := SwitchWorkspace(DefaultWorkspace); err != nil {
+		t.Fatal(err)
+	}
+	if got := PublishedAgents(defaultCfg); len(got) != 1 || got[0].Name != first[0].Name {
+		t.Errorf("default workspace published agents = %v, want %v", got, first)
+	}
+
+	// Rotating replaces the copy in the workspace's own directory.
+	defaultCfg.AgentPlatforms = []string{"linux/amd64"}
+	rotated, err := PublishAgents(defaultCfg, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated[0].Name == first[0].Name {
+		t.Fatal("rotate kept the random name")
+	}
+	if _, err := os.Stat(filepath.Join(defaultCfg.FileDirectory, first[0].Name)); !os.IsNotExist(err) {
+		t.Errorf("rotated-out copy still present: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(op2Cfg.FileDirectory, second[0].Name)); err != nil {
+		t.Errorf("agent published by op2 was removed: %v", err)
+	}
+}
+
+func TestPublishAgentsKeepsOtherFiles(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	installDir, _ := LigoloInstallDir()
+	if err := os.MkdirAll(installDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(installDir, "agent_linux_amd64"), "ELF agent")
+	writeTestFile(t, filepath.Join(installDir, "agent_windows_amd64.exe"), "MZ agent")
+
+	cfg := DefaultConfig()
+	cfg.FileDirectory = t.TempDir()
+	cfg.AgentPlatforms = []string{"linux/amd64", "windows/amd64"}
+	operator := filepath.Join(cfg.FileDirectory, "agent_windows_amd64.exe")
+	writeTestFile(t, operator, "operator's build")
+
+	if _, err := PublishAgents(cfg, false); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("publish over an operator file = %v", err)
+	}
+	if data, _ := os.ReadFile(operator); string(data) != "operator's build" {
+		t.Fatalf("operator file overwritten: %q", data)
+	}
+	// The platform published before the failure is recorded.
+	if got := PublishedAgents(cfg); len(got) != 1 || got[0].Platform != "linux/amd64" {
+		t.Errorf("published after a partial failure = %v", got)
+	}
+
+	if err := os.Remove(operator); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if agents, err := PublishAgents(cfg, false); err != nil || len(agents) != 2 {
+			t.Fatalf("publish %d = %v, %v", i, agents, err)
+		}
+	}
+	if data, _ := os.ReadFile(operator); string(data) != "MZ agent" {
+		t.Errorf("published windows agent = %q", data)
+	}
+}
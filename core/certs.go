@@ -0,0 +1,367 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Proxy TLS certificate modes.
+const (
+	CertModeSelfCert = "selfcert" // ligolo generates its own certificate
+	CertModeCustom   = "custom"   // ProxyCertFile/ProxyKeyFile
+	CertModeLocalCA  = "localca"  // leaf issued by a CA kept in the app data dir
+)
+
+const (
+	localCAValidity   = 10 * 365 * 24 * time.Hour
+	localLeafValidity = 365 * 24 * time.Hour
+	// localLeafRenewal reissues the leaf when it expires within this window.
+	localLeafRenewal = 30 * 24 * time.Hour
+)
+
+// CertDir returns the directory holding the local CA and proxy certificate.
+func CertDir() (string, error) {
+	base, err := DefaultAppDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "certs"), nil
+}
+
+// proxyCertFiles returns the certificate and key the proxy should use, or
+// empty paths for selfcert mode. In localca mode the certificates are created
+// or reissued as needed, so it is only called when starting the proxy.
+func proxyCertFiles(cfg Config) (string, string, error) {
+	switch cfg.ProxyCertMode {
+	case CertModeCustom:
+		if cfg.ProxyCertFile == "" || cfg.ProxyKeyFile == "" {
+			return "", "", errors.New("custom cert mode needs proxy_cert_file and proxy_key_file")
+		}
+		return cfg.ProxyCertFile, cfg.ProxyKeyFile, nil
+	case CertModeLocalCA:
+		return EnsureLocalCA(cfg)
+	default:
+		return "", "", nil
+	}
+}
+
+// ProxyCertFingerprint returns the SHA-256 fingerprint (upper-case hex) that
+// agents should pin for the proxy described by cfg. For a running proxy it
+// is cfg.ProxyFingerprint. Otherwise the current custom or local CA
+// certificate is read, without creating or reissuing it; a selfcert proxy's
+// fingerprint is unknown until it starts.
+func ProxyCertFingerprint(cfg Config) (string, error) {
+	if cfg.ProxyFingerprint != "" {
+		return cfg.ProxyFingerprint, nil
+	}
+	switch cfg.ProxyCertMode {
+	case CertModeCustom:
+		if cfg.ProxyCertFile == "" {
+			return "", errors.New("custom cert mode needs proxy_cert_file and proxy_key_file")
+		}
+		return certFileFingerprint(cfg.ProxyCertFile)
+	case CertModeLocalCA:
+		dir, err := CertDir()
+		if err != nil {
+			return "", err
+		}
+		return certFileFingerprint(filepath.Join(dir, "proxy.pem"))
+	default:
+		return "", nil
+	}
+}
+
+// certFileFingerprint hashes the first certificate in a PEM file.
+func certFileFingerprint(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return "", fmt.Errorf("%s: no certificate found", path)
+		}
+		if block.Type == "CERTIFICATE" {
+			sum := sha256.Sum256(block.Bytes)
+			return fmt.Sprintf("%X", sum), nil
+		}
+	}
+}
+
+// EnsureLocalCA creates the local CA on first use and (re)issues the proxy
+// leaf certificate when it is missing, close to expiry, or no longer covers
+// the configured public IP/domain and bind address. It returns the leaf
+// certificate and key paths.
+func EnsureLocalCA(cfg Config) (string, string, error) {
+	dir, err := CertDir()
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", err
+	}
+
+	caCertPath := filepath.Join(dir, "ca.pem")
+	caKeyPath := filepath.Join(dir, "ca-key.pem")
+	leafCertPath := filepath.Join(dir, "proxy.pem")
+	leafKeyPath := filepath.Join(dir, "proxy-key.pem")
+
+	caCert, caKey, err := loadCertAndKey(caCertPath, caKeyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		caCert, caKey, err = createLocalCA(caCertPath, caKeyPath)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("local CA: %w", err)
+	}
+
+	dnsNames, ips := certNames(cfg, cfg.ProxyBind)
+	leaf, _, err := loadCertAndKey(leafCertPath, leafKeyPath)
+	if err == nil && leafValid(leaf, caCert, dnsNames, ips) {
+		return leafCertPath, leafKeyPath, nil
+	}
+	if err := issueLeaf(caCert, caKey, dnsNames, ips, leafCertPath, leafKeyPath); err != nil {
+		return "", "", fmt.Errorf("proxy certificate: %w", err)
+	}
+	return leafCertPath, leafKeyPath, nil
+}
+
+// certNames returns the SANs a certificate for a listener on bind must
+// carry: localhost, the public IP/domain and the bind address.
+func certNames(cfg Config, bind string) ([]string, []net.IP) {
+	dnsNames := []string{"localhost"}
+	ips := []net.IP{net.IPv4(127, 0, 0, 1)}
+	// A PublicIP naming an interface is covered by its current address.
+	publicHost, _ := ResolvePublicIP(cfg)
+	for _, host := range []string{publicHost, bind} {
+		if host == "" || host == defaultPublicIP {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			if !ip.IsUnspecified() && !slices.ContainsFunc(ips, ip.Equal) {
+				ips = append(ips, ip)
+			}
+		} else if !slices.Contains(dnsNames, host) {
+			dnsNames = append(dnsNames, host)
+		}
+	}
+	sort.Strings(dnsNames)
+	return dnsNames, ips
+}
+
+func leafValid(leaf, ca *x509.Certificate, dnsNames []string, ips []net.IP) bool {
+	return leaf.CheckSignatureFrom(ca) == nil && certCurrent(leaf, dnsNames, ips)
+}
+
+// certCurrent reports whether cert is not close to expiry and covers every
+// given name and address.
+func certCurrent(cert *x509.Certificate, dnsNames []string, ips []net.IP) bool {
+	if time.Until(cert.NotAfter) < localLeafRenewal {
+		return false
+	}
+	for _, name := range dnsNames {
+		if !slices.Contains(cert.DNSNames, name) {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		if !slices.ContainsFunc(cert.IPAddresses, ip.Equal) {
+			return false
+		}
+	}
+	return true
+}
+
+// FileServerCertFiles returns the certificate and key for the HTTPS file
+// server: FileCertFile/FileKeyFile when set, otherwise a self-signed
+// certificate cached under CertDir.
+func FileServerCertFiles(cfg Config) (string, string, error) {
+	if cfg.FileCertFile != "" || cfg.FileKeyFile != "" {
+		if cfg.FileCertFile == "" || cfg.FileKeyFile == "" {
+			return "", "", errors.New("file_cert_file and file_key_file must be set together")
+		}
+		return cfg.FileCertFile, cfg.FileKeyFile, nil
+	}
+	return EnsureFileServerCert(cfg)
+}
+
+// EnsureFileServerCert (re)generates the self-signed file server certificate
+// when it is missing, close to expiry, or no longer covers the public
+// IP/domain and file server bind address.
+func EnsureFileServerCert(cfg Config) (string, string, error) {
+	dir, err := CertDir()
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", err
+	}
+	certPath := filepath.Join(dir, "fileserver.pem")
+	keyPath := filepath.Join(dir, "fileserver-key.pem")
+
+	dnsNames, ips := certNames(cfg, cfg.FileBind)
+	cert, _, err := loadCertAndKey(certPath, keyPath)
+	if err == nil && certCurrent(cert, dnsNames, ips) {
+		return certPath, keyPath, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(localLeafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	if err := writeCertAndKey(certPath, keyPath, der, key); err != nil {
+		return "", "", fmt.Errorf("file server certificate: %w", err)
+	}
+	return certPath, keyPath, nil
+}
+
+// FileServerCertFingerprint returns the SHA-256 fingerprint of the file
+// server certificate, or "" when TLS is off or the certificate has not been
+// generated yet.
+func FileServerCertFingerprint(cfg Config) string {
+	if !cfg.FileTLS {
+		return ""
+	}
+	certFile := cfg.FileCertFile
+	if certFile == "" {
+		dir, err := CertDir()
+		if err != nil {
+			return ""
+		}
+		certFile = filepath.Join(dir, "fileserver.pem")
+	}
+	fp, _ := certFileFingerprint(certFile)
+	return fp
+}
+
+func createLocalCA(certPath, keyPath string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "PivotOnTheGO Local CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(localCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeCertAndKey(certPath, keyPath, der, key); err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+func issueLeaf(ca *x509.Certificate, caKey *ecdsa.PrivateKey, dnsNames []string, ips []net.IP, certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(localLeafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	return writeCertAndKey(certPath, keyPath, der, key)
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func writeCertAndKey(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+func loadCertAndKey(certPath, keyPath string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, fmt.Errorf("%s: invalid PEM", certPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestProxyCertFingerprintReadOnly(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := DefaultConfig()
+	cfg.ProxyCertMode = CertModeLocalCA
+	cfg.PublicIP = "10.10.14.2"
+
+	if fp, err := ProxyCertFingerprint(cfg); err == nil {
+		t.Fatalf("fingerprint %q before any certificate was issued", fp)
+	}
+	dir, err := CertDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("fingerprint lookup created %s: %v", dir, err)
+	}
+
+	certFile, _, err := proxyCertFiles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := certFileFingerprint(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(certFile)
+
+	// A new public IP needs a new leaf, but only starting the proxy issues it.
+	cfg.PublicIP = "10.10.14.3"
+	if fp, err := ProxyCertFingerprint(cfg); err != nil || fp != issued {
+		t.Errorf("ProxyCertFingerprint = %q, %v; want %q", fp, err, issued)
+	}
+	if after, _ := os.ReadFile(certFile); string(after) != string(before) {
+		t.Error("fingerprint lookup reissued the proxy certificate")
+	}
+
+	cfg.ProxyFingerprint = "AB12"
+	if fp, _ := ProxyCertFingerprint(cfg); fp != "AB12" {
+		t.Errorf("ProxyCertFingerprint = %q, want the running proxy's", fp)
+	}
+
+	selfCfg := DefaultConfig()
+	if fp, err := ProxyCertFingerprint(selfCfg); fp != "" || err != nil {
+		t.Errorf("selfcert ProxyCertFingerprint = %q, %v", fp, err)
+	}
+}
+
+func TestSupervisorPinsStartedCertificate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script proxy")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	proxy := filepath.Join(home, "proxy")
+	writeTestFile(t, proxy, "#!/bin/sh\nsleep 30\n")
+	if err := os.Chmod(proxy, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.ProxyBinary = proxy
+	cfg.ProxyCertMode = CertModeLocalCA
+	cfg.PublicIP = "10.10.14.2"
+
+	reg := NewProxyRegistry("")
+	defer reg.Close()
+	sup, err := reg.Supervisor(DefaultProxyInstance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sup.Start(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer sup.Stop()
+	if err := sup.Start(cfg); !errors.Is(err, ErrProxyRunning) {
+		t.Errorf("second Start = %v, want ErrProxyRunning", err)
+	}
+
+	dir, _ := CertDir()
+	started, err := certFileFingerprint(filepath.Join(dir, "proxy.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp := sup.Fingerprint(); fp != started {
+		t.Fatalf("supervisor fingerprint %q, want %q", fp, started)
+	}
+	st, err := readProxyRunState(sup.runPath(".json"))
+	if err != nil || st.Fingerprint != started {
+		t.Fatalf("run state fingerprint %q, %v; want %q", st.Fingerprint, err, started)
+	}
+
+	// The public IP moves while the proxy runs: commands keep pinning the
+	// certificate it serves.
+	cfg.PublicIP = "10.10.14.3"
+	instCfg, err := reg.InstanceConfig(cfg, DefaultProxyInstance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd := AgentCmdLinux(instCfg); !strings.Contains(cmd, "-accept-fingerprint "+started) {
+		t.Errorf("agent command does not pin the running proxy's certificate: %s", cmd)
+	}
+
+	// A UI restarted while the proxy runs adopts the recorded fingerprint.
+	reg2 := NewProxyRegistry("")
+	defer reg2.Close()
+	if adopted, errs := reg2.AdoptAll(cfg); len(adopted) != 1 || len(errs) != 0 {
+		t.Fatalf("AdoptAll = %v, %v", adopted, errs)
+	}
+	sup2, _ := reg2.Supervisor(DefaultProxyInstance)
+	if fp := sup2.Fingerprint(); fp != started {
+		t.Errorf("adopted supervisor fingerprint %q, want %q", fp, started)
+	}
+
+	sup.Stop()
+	if fp := sup.Fingerprint(); fp != "" {
+		t.Errorf("fingerprint %q after stop", fp)
+	}
+}
+
+func TestEnsureFileServerCert(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := DefaultConfig()
+	cfg.PublicIP = "10.10.14.2"
+	cfg.FileTLS = true
+
+	certPath, keyPath, err := FileServerCertFiles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(keyPath); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("key file mode: %v, %v", fi.Mode(), err)
+	}
+	cert, _, err := loadCertAndKey(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.VerifyHostname("10.10.14.2"); err != nil {
+		t.Errorf("certificate does not cover the public IP: %v", err)
+	}
+	first, _ := os.ReadFile(certPath)
+
+	// An unchanged config reuses the certificate...
+	if _, _, err := EnsureFileServerCert(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(certPath); string(again) != string(first) {
+		t.Error("certificate regenerated for an unchanged config")
+	}
+	// ...and a new public IP replaces it.
+	cfg.PublicIP = "vpn.example.com"
+	if _, _, err := EnsureFileServerCert(cfg); err != nil {
+		t.Fatal(err)
+	}
+	cert, _, _ = loadCertAndKey(certPath, keyPath)
+	if err := cert.VerifyHostname("vpn.example.com"); err != nil {
+		t.Errorf("certificate not regenerated for a new public IP: %v", err)
+	}
+
+	// Supplied files are used as they are, and must come in pairs.
+	cfg.FileCertFile, cfg.FileKeyFile = "/srv/cert.pem", "/srv/key.pem"
+	if c, k, err := FileServerCertFiles(cfg); err != nil || c != cfg.FileCertFile || k != cfg.FileKeyFile {
+		t.Errorf("FileServerCertFiles = %q, %q, %v", c, k, err)
+	}
+	cfg.FileKeyFile = ""
+	if _, _, err := FileServerCertFiles(cfg); err == nil {
+		t.Error("a cert file without a key was accepted")
+	}
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// LigoloChecksumsURL returns the URL of the release checksums.txt file.
+func LigoloChecksumsURL(base, version string) string {
+	bare := strings.TrimPrefix(version, "v")
+	return fmt.Sprintf("%s/%s/ligolo-ng_%s_checksums.txt", base, version, bare)
+}
+
+// FetchLigoloChecksums downloads and parses the checksums.txt for a release.
+// The file is kept in the local release cache; if the download fails, a
+// previously cached copy is used so installs keep working offline.
+func FetchLigoloChecksums(ctx context.Context, base, version string) (map[string]string, error) {
+	bare := strings.TrimPrefix(version, "v")
+	cachePath, err := ligoloCachePath(version, fmt.Sprintf("ligolo-ng_%s_checksums.txt", bare))
+	if err != nil {
+		return nil, err
+	}
+
+	_ = os.Remove(cachePath + ".part")
+	if dlErr := downloadFile(ctx, LigoloChecksumsURL(base, version), cachePath, nil); dlErr != nil {
+		if _, err := os.Stat(cachePath); err != nil {
+			return nil, dlErr
+		}
+	}
+
+	f, err := os.Open(cachePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseChecksums(f)
+}
+
+// parseChecksums reads "<sha256>  <filename>" lines as produced by sha256sum.
+func parseChecksums(r io.Reader) (map[string]string, error) {
+	sums := map[string]string{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		sum := strings.ToLower(fields[0])
+		if len(sum) != sha256.Size*2 {
+			continue
+		}
+		if _, err := hex.DecodeString(sum); err != nil {
+			continue
+		}
+		sums[strings.TrimPrefix(fields[1], "*")] = sum
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(sums) == 0 {
+		return nil, fmt.Errorf("no checksums found")
+	}
+	return sums, nil
+}
+
+// expectedSHA256 returns the published hash an asset must match.
+func expectedSHA256(sums map[string]string, asset string) (string, error) {
+	published, ok := sums[asset]
+	if !ok {
+		return "", fmt.Errorf("no published checksum for %s", asset)
+	}
+	return published, nil
+}
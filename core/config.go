@@ -2,6 +2,9 @@ package core
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,52 +16,140 @@ const (
 	defaultPublicIP    = "CHANGEME_PUBLIC_IP"
 	defaultProxyBinary = "/opt/ligolo/proxy"
 	defaultAgentBinary = "agent"
+
+	defaultProxyAPIAddr = "127.0.0.1:11602"
+	defaultProxyAPIUser = "ligolo"
 )
 
 // Config holds settings for the PivotOnTheGO wrapper.
 type Config struct {
+	// SchemaVersion is the file format version; see ConfigSchemaVersion.
+	SchemaVersion int `json:"schema_version"`
+
 	ProxyBind   string `json:"proxy_bind"`
 	ProxyPort   int    `json:"proxy_port"`
-	PublicIP    string `json:"public_ip"`
+	PublicIP    string `json:"public_ip"` // IP, domain or "iface:<name>"
 	ProxyBinary string `json:"proxy_binary"`
 	AgentBinary string `json:"agent_binary"`
 
+	// ProxyCertMode selects the proxy's TLS certificate: CertModeSelfCert,
+	// CertModeCustom (ProxyCertFile/ProxyKeyFile) or CertModeLocalCA.
+	ProxyCertMode string `json:"proxy_cert_mode"`
+	ProxyCertFile string `json:"proxy_cert_file"`
+	ProxyKeyFile  string `json:"proxy_key_file"`
+	// ProxyFingerprint is the fingerprint of the certificate the running
+	// proxy serves: the one it was started with, or the one a selfcert proxy
+	// reported at startup. It is filled in at runtime and never saved.
+	ProxyFingerprint string `json:"-"`
+	// ProxyInstances are additional named proxies run alongside the default.
+	ProxyInstances []ProxyInstance `json:"proxy_instances"`
+
+	// ProxyAutoRestart restarts a crashed proxy with exponential backoff,
+	// up to ProxyMaxRestarts times (0 means no limit).
+	ProxyAutoRestart bool `json:"proxy_auto_restart"`
+	ProxyMaxRestarts int  `json:"proxy_max_restarts"`
+
+	// ProxyAPIEnabled starts the proxy with its web API listening on
+	// ProxyAPIAddr so agents, tunnels and listeners can be driven from the UI.
+	// ProxyAPIPassword is generated on the first start when empty.
+	ProxyAPIEnabled  bool   `json:"proxy_api_enabled"`
+	ProxyAPIAddr     string `json:"proxy_api_addr"`
+	ProxyAPIUser     string `json:"proxy_api_user"`
+	ProxyAPIPassword string `json:"proxy_api_password"`
+
+	// AgentPlatforms lists the "os/arch" agent builds Skiddie Mode installs.
+	AgentPlatforms []string `json:"agent_platforms"`
+	// LigoloVersion is the active side-by-side install; empty means the
+	// legacy unversioned layout directly under LigoloInstallDir.
+	LigoloVersion string `json:"ligolo_version"`
+	// LigoloMirror replaces the GitHub release download URL when set.
+	LigoloMirror string `json:"ligolo_mirror"`
+	// AgentPublish copies the installed agent builds into FileDirectory after
+	// Skiddie installs and version switches, so download commands work as is.
+	// AgentPublishRandom gives the copies random file names.
+	AgentPublish       bool `json:"agent_publish"`
+	AgentPublishRandom bool `json:"agent_publish_random"`
+
 	FileBind      string `json:"file_bind"`
 	FilePort      int    `json:"file_port"`
 	FileDirectory string `json:"file_directory"`
+	// FileUpload accepts PUT/POST uploads under /upload/ on the file server,
+	// authenticated with FileUploadToken (generated when empty). Uploads over
+	// FileUploadMaxMB are rejected.
+	FileUpload      bool   `json:"file_upload"`
+	FileUploadToken string `json:"file_upload_token"`
+	FileUploadMaxMB int    `json:"file_upload_max_mb"`
+	// FileTLS serves the file server over HTTPS, with FileCertFile and
+	// FileKeyFile or a generated self-signed certificate.
+	FileTLS      bool   `json:"file_tls"`
+	FileCertFile string `json:"file_cert_file"`
+	FileKeyFile  string `json:"file_key_file"`
+	// FileLinksOnly serves files only through tokenized /d/<token>/<name>
+	// links. New links expire after FileLinkTTLMinutes and allow
+	// FileLinkMaxDownloads downloads unless minted with other limits.
+	FileLinksOnly        bool `json:"file_links_only"`
+	FileLinkTTLMinutes   int  `json:"file_link_ttl_minutes"`
+	FileLinkMaxDownloads int  `json:"file_link_max_downloads"`
 }
 
 // DefaultConfig returns a configuration populated with safe defaults.
 func DefaultConfig() Config {
 	return Config{
-		ProxyBind:     defaultProxyBind,
-		ProxyPort:     defaultProxyPort,
-		PublicIP:      defaultPublicIP,
-		ProxyBinary:   defaultProxyBinary,
-		AgentBinary:   defaultAgentBinary,
+		ProxyBind:      defaultProxyBind,
+		ProxyPort:      defaultProxyPort,
+		PublicIP:       defaultPublicIP,
+		ProxyBinary:    defaultProxyBinary,
+		AgentBinary:    defaultAgentBinary,
+		AgentPlatforms: DefaultAgentPlatforms(),
+		ProxyCertMode:  CertModeSelfCert,
+
+		ProxyAPIAddr: defaultProxyAPIAddr,
+		ProxyAPIUser: defaultProxyAPIUser,
+
 		FileBind:      "0.0.0.0",
 		FilePort:      8000,
 		FileDirectory: "",
+
+		FileUploadMaxMB: defaultFileUploadMaxMB,
+
+		FileLinkTTLMinutes:   defaultFileLinkTTLMinutes,
+		FileLinkMaxDownloads: defaultFileLinkMaxDownloads,
 	}
 }
 
-// ConfigPath returns the config file location in the user's home directory.
+// ConfigPath returns the config file of the active workspace. The default
+// workspace uses the global config file in ConfigDir.
 func ConfigPath() (string, error) {
-	home, err := os.UserHomeDir()
+	return workspaceConfigPath(ActiveWorkspace())
+}
+
+// ConfigDir returns the directory holding the global config files.
+func ConfigDir() (string, error) {
+	path, err := globalConfigPath()
 	if err != nil {
 		return "", err
 	}
-	newPath := filepath.Join(home, ".config", "PivotOnTheGO", "config.json")
-	oldPath := filepath.Join(home, ".config", "SwissArmyToolkit", "config.json")
+	return filepath.Dir(path), nil
+}
 
-	// Prefer new path; fall back to legacy if it already exists.
-	if _, err := os.Stat(newPath); err == nil {
-		return newPath, nil
+// globalConfigPath returns the config file location in the user's home
+// directory, unless overridden by --config or POTG_CONFIG.
+func globalConfigPath() (string, error) {
+	if path := configPathOverride(); path != "" {
+		return path, nil
 	}
-	if _, err := os.Stat(oldPath); err == nil {
-		return oldPath, nil
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
 	}
-	return newPath, nil
+	return filepath.Join(home, ".config", "PivotOnTheGO", "config.json"), nil
+}
+
+// legacyConfigPath returns the historical config file location. It is only
+// read, to migrate it to globalConfigPath.
+func legacyConfigPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "SwissArmyToolkit", "config.json")
 }
 
 // SanitizeConfig trims and validates configuration values, applying defaults when needed.
@@ -67,15 +158,15 @@ func SanitizeConfig(cfg Config) Config {
 	cfg.PublicIP = strings.TrimSpace(cfg.PublicIP)
 	cfg.ProxyBinary = strings.TrimSpace(cfg.ProxyBinary)
 	cfg.AgentBinary = strings.TrimSpace(cfg.AgentBinary)
+	cfg.ProxyCertFile = strings.TrimSpace(cfg.ProxyCertFile)
+	cfg.ProxyKeyFile = strings.TrimSpace(cfg.ProxyKeyFile)
+	cfg.ProxyCertMode = strings.TrimSpace(cfg.ProxyCertMode)
 	cfg.FileBind = strings.TrimSpace(cfg.FileBind)
 	cfg.FileDirectory = strings.TrimSpace(cfg.FileDirectory)
-
-	oldAppData := LegacyAppDataDirPath()
-	newAppData, _ := DefaultAppDataDir()
-	oldLoot := filepath.Join(oldAppData, "loot")
-	newLoot := filepath.Join(newAppData, "loot")
-	oldProxy := filepath.Join(oldAppData, "ligolo", "proxy")
-	newProxy := filepath.Join(newAppData, "ligolo", "proxy")
+	cfg.LigoloVersion = strings.TrimSpace(cfg.LigoloVersion)
+	cfg.LigoloMirror = strings.TrimSpace(cfg.LigoloMirror)
+	cfg.ProxyAPIAddr = strings.TrimSpace(cfg.ProxyAPIAddr)
+	cfg.ProxyAPIUser = strings.TrimSpace(cfg.ProxyAPIUser)
 
 	if cfg.ProxyPort <= 0 || cfg.ProxyPort > 65535 {
 		cfg.ProxyPort = defaultProxyPort
@@ -86,34 +177,73 @@ func SanitizeConfig(cfg Config) Config {
 	if cfg.ProxyBinary == "" {
 		cfg.ProxyBinary = defaultProxyBinary
 	}
-	if cfg.ProxyBinary == oldProxy {
-		if _, err := os.Stat(newProxy); err == nil {
-			cfg.ProxyBinary = newProxy
-		}
-	}
 	if cfg.AgentBinary == "" {
 		cfg.AgentBinary = defaultAgentBinary
 	}
+	switch cfg.ProxyCertMode {
+	case CertModeSelfCert, CertModeCustom, CertModeLocalCA:
+	default:
+		cfg.ProxyCertMode = CertModeSelfCert
+	}
+	cfg.ProxyInstances = sanitizeProxyInstances(cfg)
+	if cfg.ProxyMaxRestarts < 0 {
+		cfg.ProxyMaxRestarts = 0
+	}
+	if _, _, err := net.SplitHostPort(cfg.ProxyAPIAddr); err != nil {
+		cfg.ProxyAPIAddr = defaultProxyAPIAddr
+	}
+	if cfg.ProxyAPIUser == "" {
+		cfg.ProxyAPIUser = defaultProxyAPIUser
+	}
+	platforms := []string{}
+	seen := map[string]bool{}
+	for _, spec := range cfg.AgentPlatforms {
+		p, err := ParseLigoloPlatform(spec)
+		if err != nil || seen[p.String()] {
+			continue
+		}
+		seen[p.String()] = true
+		platforms = append(platforms, p.String())
+	}
+	if len(platforms) == 0 {
+		platforms = DefaultAgentPlatforms()
+	}
+	cfg.AgentPlatforms = platforms
+	if ValidateLigoloVersion(cfg.LigoloVersion) != nil {
+		cfg.LigoloVersion = ""
+	}
 	if cfg.FilePort <= 0 || cfg.FilePort > 65535 {
 		cfg.FilePort = 8000
 	}
 	if cfg.FileBind == "" {
 		cfg.FileBind = "0.0.0.0"
 	}
+	cfg.FileUploadToken = strings.TrimSpace(cfg.FileUploadToken)
+	cfg.FileCertFile = strings.TrimSpace(cfg.FileCertFile)
+	cfg.FileKeyFile = strings.TrimSpace(cfg.FileKeyFile)
+	if cfg.FileUploadMaxMB <= 0 {
+		cfg.FileUploadMaxMB = defaultFileUploadMaxMB
+	}
+	if cfg.FileLinkTTLMinutes <= 0 {
+		cfg.FileLinkTTLMinutes = defaultFileLinkTTLMinutes
+	}
+	if cfg.FileLinkMaxDownloads <= 0 {
+		cfg.FileLinkMaxDownloads = defaultFileLinkMaxDownloads
+	}
 	if cfg.FileDirectory == "" {
 		if lootDir, err := InitLootDir(); err == nil {
 			cfg.FileDirectory = lootDir
 		}
-	} else if cfg.FileDirectory == oldLoot {
-		if _, err := os.Stat(newLoot); err == nil {
-			cfg.FileDirectory = newLoot
-		}
 	}
 	return cfg
 }
 
 // LoadConfig reads the configuration file if it exists, or returns defaults.
 // If the file is missing, it returns DefaultConfig and os.ErrNotExist.
+// Older files are migrated and rewritten; an unreadable file is replaced by
+// its last known-good backup when there is one. A file from a newer build is
+// left alone and ErrConfigTooNew returned. Flag and environment
+// overrides are applied on top in every case.
 func LoadConfig() (Config, error) {
 	path, err := ConfigPath()
 	if err != nil {
@@ -121,38 +251,66 @@ func LoadConfig() (Config, error) {
 	}
 
 	data, err := os.ReadFile(path)
+	fromLegacy := false
+	if os.IsNotExist(err) {
+		if global, gerr := globalConfigPath(); gerr == nil && path == global && configPathOverride() == "" {
+			data, err = os.ReadFile(legacyConfigPath())
+			fromLegacy = err == nil
+		}
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
-			return DefaultConfig(), os.ErrNotExist
+			return SanitizeConfig(applyConfigOverrides(DefaultConfig())), os.ErrNotExist
 		}
 		return DefaultConfig(), err
 	}
 
-	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return DefaultConfig(), err
+	cfg, migrated, err := decodeConfig(data)
+	if err != nil {
+		if !fromLegacy && !errors.Is(err, ErrConfigTooNew) {
+			if restored, rerr := restoreConfigBackup(path); rerr == nil {
+				return SanitizeConfig(applyConfigOverrides(restored)), nil
+			}
+		}
+		return DefaultConfig(), fmt.Errorf("%s: %w", path, err)
+	}
+	if migrated || fromLegacy {
+		// Best effort: the migrated config is usable even if it can't be saved.
+		_ = writeConfig(path, cfg)
 	}
-	return SanitizeConfig(cfg), nil
+	return SanitizeConfig(applyConfigOverrides(cfg)), nil
 }
 
 // SaveConfig writes the configuration to disk after sanitizing it.
 func SaveConfig(cfg Config) error {
-	cfg = SanitizeConfig(cfg)
-
 	path, err := ConfigPath()
 	if err != nil {
 		return err
 	}
+	return writeConfig(path, cfg)
+}
 
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+// writeConfig sanitizes and saves cfg without its flag and environment
+// overrides, keeping the previous file as the backup if it was valid.
+func writeConfig(path string, cfg Config) error {
+	cfg = stripConfigOverrides(path, SanitizeConfig(cfg))
+	if err := backupConfig(path); err != nil {
 		return err
 	}
+	return writeConfigFile(path, cfg)
+}
 
+// writeConfigFile atomically writes cfg readable only by the user, since it
+// holds the proxy API credentials.
+func writeConfigFile(path string, cfg Config) error {
+	cfg.SchemaVersion = ConfigSchemaVersion
 	data, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
 		return err
 	}
-
-	return os.WriteFile(path, data, 0o644)
+	if err := writeFileAtomic(path, data, 0o600); err != nil {
+		return err
+	}
+	// Tighten directories created by older versions.
+	return os.Chmod(filepath.Dir(path), 0o700)
 }
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigSchemaVersion is the config file format written by this build. Files
+// with an older (or no) schema_version are upgraded by configMigrations when
+// loaded.
+const ConfigSchemaVersion = 1
+
+// ErrConfigTooNew is returned for config files written by a newer build. They
+// are never restored from backup or overwritten, so running an older binary
+// can't destroy them.
+var ErrConfigTooNew = errors.New("config was written by a newer version")
+
+// configMigrations[i] upgrades a raw config from schema version i to i+1.
+var configMigrations = []func(raw map[string]interface{}){
+	migrateLegacyAppData,
+}
+
+// migrateLegacyAppData points paths inside the old SwissArmyToolkit app data
+// dir at their PivotOnTheGO equivalents once those exist.
+func migrateLegacyAppData(raw map[string]interface{}) {
+	newAppData, err := DefaultAppDataDir()
+	if err != nil {
+		return
+	}
+	oldAppData := LegacyAppDataDirPath()
+	for _, key := range []string{"file_directory", "proxy_binary"} {
+		value, _ := raw[key].(string)
+		value = strings.TrimSpace(value)
+		rel, err := filepath.Rel(oldAppData, value)
+		if value == "" || err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		newPath := filepath.Join(newAppData, rel)
+		if _, err := os.Stat(newPath); err == nil {
+			raw[key] = newPath
+		}
+	}
+}
+
+// decodeConfig parses a config file, running any migrations it needs.
+// migrated reports whether the file should be rewritten.
+func decodeConfig(data []byte) (cfg Config, migrated bool, err error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return cfg, false, err
+	}
+	if raw == nil {
+		return cfg, false, fmt.Errorf("config is not a JSON object")
+	}
+
+	version := 0
+	if v, ok := raw["schema_version"].(float64); ok {
+		version = int(v)
+	}
+	if version > ConfigSchemaVersion {
+		return cfg, false, fmt.Errorf("%w: schema version %d, this build supports %d", ErrConfigTooNew, version, ConfigSchemaVersion)
+	}
+	for ; version < ConfigSchemaVersion; version++ {
+		configMigrations[version](raw)
+		migrated = true
+	}
+	raw["schema_version"] = ConfigSchemaVersion
+
+	data, err = json.Marshal(raw)
+	if err != nil {
+		return cfg, false, err
+	}
+	err = json.Unmarshal(data, &cfg)
+	return cfg, migrated, err
+}
+
+// ConfigBackupPath returns where the last known-good copy of the config at
+// path is kept.
+func ConfigBackupPath(path string) string {
+	return path + ".bak"
+}
+
+// backupConfig copies the config at path to its backup file if it still
+// parses, so a bad write or edit can be rolled back. It fails with
+// ErrConfigTooNew rather than let a newer config be replaced.
+func backupConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if _, _, err := decodeConfig(data); errors.Is(err, ErrConfigTooNew) {
+		return fmt.Errorf("%s: %w", path, err)
+	} else if err != nil {
+		return nil
+	}
+	return writeFileAtomic(ConfigBackupPath(path), data, 0o600)
+}
+
+// restoreConfigBackup replaces an unreadable config at path with its backup.
+// The unreadable file is kept next to it with a .corrupt suffix.
+func restoreConfigBackup(path string) (Config, error) {
+	data, err := os.ReadFile(ConfigBackupPath(path))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg, _, err := decodeConfig(data)
+	if err != nil {
+		return Config{}, err
+	}
+	_ = os.Rename(path, path+".corrupt")
+	if err := writeConfigFile(path, cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// writeFileAtomic writes data to a temp file in the target directory and
+// renames it into place, so readers never see a partial file. Missing
+// directories are created private to the user.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestLoadConfigNewerSchema(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path, err := ConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.PublicIP = "10.0.0.1"
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	// Saving again leaves the first version as the backup.
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ConfigBackupPath(path)); err != nil {
+		t.Fatalf("no backup: %v", err)
+	}
+
+	newer := []byte(`{"schema_version": 99, "public_ip": "10.0.0.2", "some_new_setting": true}`)
+	if err := os.WriteFile(path, newer, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadConfig(); !errors.Is(err, ErrConfigTooNew) {
+		t.Fatalf("LoadConfig = %v, want ErrConfigTooNew", err)
+	}
+	if err := SaveConfig(cfg); !errors.Is(err, ErrConfigTooNew) {
+		t.Fatalf("SaveConfig over a newer config = %v, want ErrConfigTooNew", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != string(newer) {
+		t.Errorf("newer config was changed to:\n%s", data)
+	}
+	if _, err := os.Stat(path + ".corrupt"); !os.IsNotExist(err) {
+		t.Errorf("newer config was moved aside: %v", err)
+	}
+}
+
+func TestLoadConfigRestoresCorruptFromBackup(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path, err := ConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.PublicIP = "10.0.0.1"
+	for i := 0; i < 2; i++ {
+		if err := SaveConfig(cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(path, []byte(`{"public_ip": `), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadConfig()
+	if err != nil || got.PublicIP != "10.0.0.1" {
+		t.Fatalf("LoadConfig = %q, %v; want the backup", got.PublicIP, err)
+	}
+	if data, _ := os.ReadFile(path + ".corrupt"); string(data) != `{"public_ip": ` {
+		t.Errorf("corrupt config not kept aside, got %q", data)
+	}
+}
+
+func TestDecodeBaselineConfig(t *testing.T) {
+	cfg, migrated, err := decodeConfig([]byte(`{"proxy_port": 11602, "public_ip": "10.0.0.1"}`))
+	if err != nil || !migrated || cfg.SchemaVersion != ConfigSchemaVersion {
+		t.Fatalf("decodeConfig = %d, %v, %v", cfg.SchemaVersion, migrated, err)
+	}
+	if cfg = SanitizeConfig(cfg); cfg.ProxyPort != 11602 || cfg.ProxyCertMode != CertModeSelfCert {
+		t.Errorf("baseline config decoded to port %d, cert mode %q", cfg.ProxyPort, cfg.ProxyCertMode)
+	}
+}
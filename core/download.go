@@ -0,0 +1,139 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SkiddieProgress reports installer progress for the UI.
+type SkiddieProgress struct {
+	Stage      string `json:"stage"`
+	Asset      string `json:"asset,omitempty"`
+	Downloaded int64  `json:"downloaded"`
+	Total      int64  `json:"total"`
+	Message    string `json:"message,omitempty"`
+}
+
+// ProgressFunc receives installer progress updates. It may be nil.
+type ProgressFunc func(SkiddieProgress)
+
+func (fn ProgressFunc) report(p SkiddieProgress) {
+	if fn != nil {
+		fn(p)
+	}
+}
+
+// progressWriter counts bytes and reports them at most every interval.
+type progressWriter struct {
+	fn       ProgressFunc
+	asset    string
+	written  int64
+	total    int64
+	last     time.Time
+	interval time.Duration
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	pw.written += int64(len(b))
+	if now := time.Now(); now.Sub(pw.last) >= pw.interval {
+		pw.last = now
+		pw.fn.report(SkiddieProgress{Stage: "download", Asset: pw.asset, Downloaded: pw.written, Total: pw.total})
+	}
+	return len(b), nil
+}
+
+// downloadFile fetches url into path. Data is written to path+".part" and
+// renamed when complete, so an interrupted download never leaves a partial
+// file at path. If a .part file is left over from a cancelled or failed
+// attempt, the download resumes from it with an HTTP Range request; a reply
+// for any other range than the rest of the file restarts the download.
+func downloadFile(ctx context.Context, url, path string, progress ProgressFunc) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	partPath := path + ".part"
+	var offset int64
+	if fi, err := os.Stat(partPath); err == nil {
+		offset = fi.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			// Appending any other range would corrupt the file.
+			_ = os.Remove(partPath)
+			return downloadFile(ctx, url, path, progress)
+		}
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The .part file is already complete (or stale); start over.
+		_ = os.Remove(partPath)
+		return downloadFile(ctx, url, path, progress)
+	case resp.StatusCode == http.StatusOK:
+		flags |= os.O_TRUNC
+		offset = 0
+	default:
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	out, err := os.OpenFile(partPath, flags, 0o644)
+	if err != nil {
+		return err
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	pw := &progressWriter{fn: progress, asset: filepath.Base(path), written: offset, total: total, interval: 250 * time.Millisecond}
+	pw.fn.report(SkiddieProgress{Stage: "download", Asset: pw.asset, Downloaded: offset, Total: total})
+
+	if _, err := io.Copy(io.MultiWriter(out, pw), resp.Body); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	pw.fn.report(SkiddieProgress{Stage: "download", Asset: pw.asset, Downloaded: pw.written, Total: pw.written})
+	return os.Rename(partPath, path)
+}
+
+// contentRangeStart returns the first byte of a "bytes start-end/size"
+// Content-Range header.
+func contentRangeStart(header string) (int64, bool) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	return n, err == nil
+}
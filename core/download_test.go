@@ -0,0 +1,82 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDownloadFileResume(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "asset", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "asset.tar.gz")
+	writeTestFile(t, dest+".part", string(data[:4000]))
+	if err := downloadFile(context.Background(), srv.URL, dest, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatalf("resumed download is %d bytes, want %d", len(got), len(data))
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=4000-" {
+		t.Errorf("requests with ranges %q, want one resume", ranges)
+	}
+}
+
+func TestDownloadFileWrongRange(t *testing.T) {
+	data := bytes.Repeat([]byte("abcdefghij"), 1000)
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if r.Header.Get("Range") == "" {
+			w.Write(data)
+			return
+		}
+		// A broken mirror answering every range from byte 100.
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 100-%d/%d", len(data)-1, len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[100:])
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "asset.zip")
+	writeTestFile(t, dest+".part", string(data[:4000]))
+	if err := downloadFile(context.Background(), srv.URL, dest, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatalf("download is %d bytes, want the whole file (%d)", len(got), len(data))
+	}
+	if len(ranges) != 2 || ranges[1] != "" {
+		t.Errorf("requests with ranges %q, want a restart without a range", ranges)
+	}
+}
+
+func TestContentRangeStart(t *testing.T) {
+	for header, want := range map[string]int64{
+		"bytes 4000-9999/10000": 4000,
+		"bytes 0-9/*":           0,
+		"bytes */10000":         -1,
+		"items 5-9/10":          -1,
+		"":                      -1,
+	} {
+		got, ok := contentRangeStart(header)
+		if !ok {
+			got = -1
+		}
+		if got != want {
+			t.Errorf("contentRangeStart(%q) = %d, want %d", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFileAccessEntries = 500
+	fileAccessLogMaxBytes    = 5 << 20
+	fileAccessLogKeepFiles   = 3
+)
+
+// FileAccess is one request handled by the file server.
+type FileAccess struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"`
+	UserAgent string    `json:"user_agent"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	// Bytes is the size of the response body; BytesIn that of the request
+	// body (uploads).
+	Bytes   int64 `json:"bytes"`
+	BytesIn int64 `json:"bytes_in,omitempty"`
+}
+
+// Summary describes the request in one line, e.g.
+// "10.10.20.5 downloaded agent.exe (200, 6.1 MB)".
+func (a FileAccess) Summary() string {
+	name := strings.TrimPrefix(a.Path, "/")
+	if rest, ok := strings.CutPrefix(name, "d/"); ok {
+		// Download links: d/<token>/<name>.
+		if _, file, ok := strings.Cut(rest, "/"); ok {
+			name = file
+		}
+	}
+	verb, size := "downloaded", a.Bytes
+	switch {
+	case a.Method == http.MethodPut || a.Method == http.MethodPost:
+		verb, size = "uploaded", a.BytesIn
+		name = strings.TrimPrefix(name, "upload/")
+	case name == "" || strings.HasSuffix(name, "/"):
+		verb = "listed"
+		name = "/" + name
+	}
+	if a.Status >= 400 {
+		verb = "requested"
+		if a.Method != http.MethodGet && a.Method != http.MethodHead {
+			verb = a.Method
+		}
+	}
+	return fmt.Sprintf("%s %s %s (%d, %s)", a.Source, verb, name, a.Status, FormatBytes(size))
+}
+
+// FormatBytes renders n with a binary unit, e.g. "6.1 MB".
+func FormatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v := float64(n)
+	for _, unit := range []string{"KB", "MB", "GB"} {
+		v /= 1024
+		if v < 1024 || unit == "GB" {
+			return fmt.Sprintf("%.1f %s", v, unit)
+		}
+	}
+	return ""
+}
+
+// FileAccessLogPath returns the JSONL access log of the active workspace.
+func FileAccessLogPath() (string, error) {
+	dir, err := WorkspaceLogDir(ActiveWorkspace())
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "file-access.jsonl"), nil
+}
+
+// FileAccessLog keeps recent file server requests in a ring buffer and
+// appends them as JSON lines to a size-rotated file. Subscribers are woken
+// whenever a request is recorded.
+type FileAccessLog struct {
+	mu      sync.Mutex
+	entries []FileAccess
+	start   int
+	seq     uint64
+	notify  chan struct{}
+
+	path string
+	file *os.File
+	size int64
+}
+
+// NewFileAccessLog returns an in-memory access log; Open adds the file.
+func NewFileAccessLog() *FileAccessLog {
+	return &FileAccessLog{
+		entries: make([]FileAccess, 0, defaultFileAccessEntries),
+		notify:  make(chan struct{}),
+	}
+}
+
+// Open starts appending to the file at path. Opening a different file than
+// before (another workspace) clears the buffered entries.
+func (l *FileAccessLog) Open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	if path != l.path {
+		l.entries = l.entries[:0]
+		l.start = 0
+	}
+	l.path = path
+	return l.openFile()
+}
+
+func (l *FileAccessLog) openFile() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = fi.Size()
+	return nil
+}
+
+// Path returns the log file path, or "" before Open.
+func (l *FileAccessLog) Path() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.path
+}
+
+// Record stores a request and wakes subscribers.
+func (l *FileAccessLog) Record(a FileAccess) FileAccess {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	a.Seq = l.seq
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, a)
+	} else {
+		l.entries[l.start] = a
+		l.start = (l.start + 1) % len(l.entries)
+	}
+
+	if l.file != nil {
+		if l.size >= fileAccessLogMaxBytes {
+			l.file.Close()
+			l.file = nil
+			rotateLogFiles(l.path, fileAccessLogKeepFiles)
+			_ = l.openFile()
+		}
+		if data, err := json.Marshal(a); err == nil && l.file != nil {
+			n, _ := l.file.Write(append(data, '\n'))
+			l.size += int64(n)
+		}
+	}
+
+	close(l.notify)
+	l.notify = make(chan struct{})
+	return a
+}
+
+// Since returns buffered entries with Seq greater than seq, plus a channel
+// that is closed when more arrive.
+func (l *FileAccessLog) Since(seq uint64) ([]FileAccess, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := []FileAccess{}
+	for i := 0; i < len(l.entries); i++ {
+		a := l.entries[(l.start+i)%len(l.entries)]
+		if a.Seq > seq {
+			out = append(out, a)
+		}
+	}
+	return out, l.notify
+}
+
+// Tail returns up to n of the most recent entries.
+func (l *FileAccessLog) Tail(n int) []FileAccess {
+	entries, _ := l.Since(0)
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries
+}
+
+// Close closes the log file; the buffered entries are kept.
+func (l *FileAccessLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Handler records every request served by next. Query strings and download
+// link tokens are left out so upload tokens and usable links never reach the
+// log.
+func (l *FileAccessLog) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &accessRecorder{ResponseWriter: w, status: http.StatusOK}
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		next.ServeHTTP(rec, r)
+
+		source, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			source = r.RemoteAddr
+		}
+		l.Record(FileAccess{
+			Time:      time.Now(),
+			Source:    source,
+			UserAgent: r.UserAgent(),
+			Method:    r.Method,
+			Path:      redactLinkToken(path.Clean("/" + r.URL.Path)),
+			Status:    rec.status,
+			Bytes:     rec.bytes,
+			BytesIn:   body.n,
+		})
+	})
+}
+
+// fileLinkTokenPrefix is how much of a download link token the access log
+// keeps, enough to match an entry to its link.
+const fileLinkTokenPrefix = 6
+
+// redactLinkToken shortens the token of a /d/<token>/<name> path so the log
+// doesn't hold usable links.
+func redactLinkToken(p string) string {
+	rest, ok := strings.CutPrefix(p, "/d/")
+	if !ok {
+		return p
+	}
+	token, name, hasName := strings.Cut(rest, "/")
+	if len(token) > fileLinkTokenPrefix {
+		token = token[:fileLinkTokenPrefix] + "…"
+	}
+	if !hasName {
+		return "/d/" + token
+	}
+	return "/d/" + token + "/" + name
+}
+
+// accessRecorder captures the status and body size of a response.
+type accessRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *accessRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessRecorder) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *accessRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
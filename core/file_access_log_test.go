@@ -0,0 +1,41 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileAccessLogRedactsLinkTokens(t *testing.T) {
+	l := NewFileAccessLog()
+	logPath := filepath.Join(t.TempDir(), "file-access.jsonl")
+	if err := l.Open(logPath); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("MZ"))
+	}))
+
+	const token = "0123456789abcdef0123456789abcdef"
+	for _, target := range []string{"/d/" + token + "/agent.exe", "/d/" + token, "/upload/x?token=" + token, "/tools/agent.exe"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	want := []string{"/d/012345…/agent.exe", "/d/012345…", "/upload/x", "/tools/agent.exe"}
+	got := l.Tail(len(want))
+	for i, a := range got {
+		if a.Path != want[i] {
+			t.Errorf("entry %d path = %q, want %q", i, a.Path, want[i])
+		}
+	}
+	if s := got[0].Summary(); !strings.Contains(s, "downloaded agent.exe") {
+		t.Errorf("Summary() = %q", s)
+	}
+	if data, _ := os.ReadFile(logPath); strings.Contains(string(data), token) {
+		t.Errorf("log file holds the link token:\n%s", data)
+	}
+}
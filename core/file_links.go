@@ -0,0 +1,372 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFileLinkTTLMinutes   = 60
+	defaultFileLinkMaxDownloads = 1
+	// fileLinkRetention keeps expired links listed for a while so the
+	// operator can see what was fetched.
+	fileLinkRetention = 24 * time.Hour
+	// fileLinkRefetchWindow lets the source of a download fetch the file
+	// again shortly after without using up another download, as certutil
+	// -urlcache does.
+	fileLinkRefetchWindow = 2 * time.Minute
+)
+
+// ErrFileLinkInvalid is returned for unknown, expired, used up or revoked
+// links, and for requests from a source the link is not for. The file server
+// answers all of them with the same 404.
+var ErrFileLinkInvalid = errors.New("invalid download link")
+
+// FileLink is a tokenized download URL, /d/<token>/<name>, for one file
+// under the file server directory.
+type FileLink struct {
+	Token string `json:"token"`
+	// Path is relative to the file server directory.
+	Path         string    `json:"path"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	MaxDownloads int       `json:"max_downloads"`
+	Downloads    int       `json:"downloads"`
+	// SourceIP restricts the link to one address or CIDR range.
+	SourceIP string `json:"source_ip,omitempty"`
+	Revoked  bool   `json:"revoked,omitempty"`
+	// Shared marks links minted for generated commands in FileLinksOnly
+	// mode, which are reused until downloaded; see SharedFileLink.
+	Shared bool `json:"shared,omitempty"`
+	// LastSource and LastDownloadAt record the last counted download, so its
+	// continuations aren't counted again.
+	LastSource     string    `json:"last_source,omitempty"`
+	LastDownloadAt time.Time `json:"last_download_at"`
+}
+
+// Active reports whether the link can still be used at t.
+func (l FileLink) Active(t time.Time) bool {
+	return !l.Revoked && t.Before(l.ExpiresAt) && l.Downloads < l.MaxDownloads
+}
+
+// continues reports whether a GET by remote at t continues the last counted
+// download: a range request past the first byte, or a refetch within
+// fileLinkRefetchWindow, from the same source. Continuations work on a used
+// up link until it expires or is revoked.
+func (l FileLink) continues(remote string, resume bool, t time.Time) bool {
+	if l.Revoked || !t.Before(l.ExpiresAt) || l.Downloads == 0 || remote != l.LastSource {
+		return false
+	}
+	return resume || t.Sub(l.LastDownloadAt) < fileLinkRefetchWindow
+}
+
+// FileLinkOptions sets the limits of a new link. Zero values take the
+// file_link_* config defaults.
+type FileLinkOptions struct {
+	TTL          time.Duration
+	MaxDownloads int
+	SourceIP     string
+}
+
+// fileLinkState holds the links of the active workspace. Links are only
+// served from Dir, the file server directory they were minted for.
+type fileLinkState struct {
+	Dir   string     `json:"dir"`
+	Links []FileLink `json:"links"`
+}
+
+var fileLinksMu sync.Mutex
+
+// FileLinksPath returns the file recording the download links of the active
+// workspace, next to its loot dir.
+func FileLinksPath() (string, error) {
+	lootDir, err := DefaultLootDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(lootDir), "file_links.json"), nil
+}
+
+func readFileLinks() (fileLinkState, error) {
+	st := fileLinkState{Links: []FileLink{}}
+	p, err := FileLinksPath()
+	if err != nil {
+		return st, err
+	}
+	data, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, err
+	}
+	if st.Links == nil {
+		st.Links = []FileLink{}
+	}
+	return st, nil
+}
+
+// writeFileLinks saves st, dropping links that expired over
+// fileLinkRetention ago.
+func writeFileLinks(st fileLinkState) error {
+	p, err := FileLinksPath()
+	if err != nil {
+		return err
+	}
+	kept := []FileLink{}
+	for _, l := range st.Links {
+		if time.Since(l.ExpiresAt) < fileLinkRetention {
+			kept = append(kept, l)
+		}
+	}
+	st.Links = kept
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, data, 0o600)
+}
+
+// ValidateSourceIP checks a link's source restriction: an IP address or a
+// CIDR range. Empty means any source.
+func ValidateSourceIP(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" || net.ParseIP(s) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(s); err == nil {
+		return nil
+	}
+	return fmt.Errorf("%q is not an IP address or CIDR range", s)
+}
+
+func sourceAllowed(restriction, remote string) bool {
+	if restriction == "" {
+		return true
+	}
+	ip := net.ParseIP(remote)
+	if ip == nil {
+		return false
+	}
+	if _, n, err := net.ParseCIDR(restriction); err == nil {
+		return n.Contains(ip)
+	}
+	return ip.Equal(net.ParseIP(restriction))
+}
+
+// CreateFileLink mints a download link for rel, a file under the file server
+// directory.
+func CreateFileLink(cfg Config, rel string, opts FileLinkOptions) (FileLink, error) {
+	return createFileLink(cfg, rel, opts, false)
+}
+
+// SharedFileLink returns a link for rel with the default limits, for
+// commands generated in FileLinksOnly mode. A shared link that hasn't been
+// used and has at least half its lifetime left is reused, so refreshing
+// commands doesn't mint a new link each time.
+func SharedFileLink(cfg Config, rel string) (FileLink, error) {
+	return createFileLink(cfg, rel, FileLinkOptions{}, true)
+}
+
+func createFileLink(cfg Config, rel string, opts FileLinkOptions, shared bool) (FileLink, error) {
+	cfg = SanitizeConfig(cfg)
+	rel, err := CleanFileServerPath(rel)
+	if err != nil {
+		return FileLink{}, err
+	}
+	if rel == "" {
+		return FileLink{}, errors.New("invalid filename")
+	}
+	if cfg.FileDirectory == "" {
+		return FileLink{}, errors.New("file server directory not configured")
+	}
+	full, err := ResolveFileServerPath(cfg.FileDirectory, rel)
+	if errors.Is(err, os.ErrNotExist) {
+		return FileLink{}, fmt.Errorf("%s is not in the file server directory", rel)
+	}
+	if err != nil {
+		return FileLink{}, err
+	}
+	if fi, err := os.Stat(full); err != nil {
+		return FileLink{}, err
+	} else if fi.IsDir() {
+		return FileLink{}, fmt.Errorf("%s is a directory", rel)
+	}
+
+	if opts.TTL < 0 || opts.MaxDownloads < 0 {
+		return FileLink{}, errors.New("link expiry and max downloads must not be negative")
+	}
+	if opts.TTL == 0 {
+		opts.TTL = time.Duration(cfg.FileLinkTTLMinutes) * time.Minute
+	}
+	if opts.MaxDownloads == 0 {
+		opts.MaxDownloads = cfg.FileLinkMaxDownloads
+	}
+	opts.SourceIP = strings.TrimSpace(opts.SourceIP)
+	if err := ValidateSourceIP(opts.SourceIP); err != nil {
+		return FileLink{}, err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return FileLink{}, err
+	}
+	now := time.Now()
+	link := FileLink{
+		Token:        hex.EncodeToString(b),
+		Path:         rel,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(opts.TTL),
+		MaxDownloads: opts.MaxDownloads,
+		SourceIP:     opts.SourceIP,
+		Shared:       shared,
+	}
+
+	fileLinksMu.Lock()
+	defer fileLinksMu.Unlock()
+	st, err := readFileLinks()
+	if err != nil {
+		return FileLink{}, err
+	}
+	if st.Dir != cfg.FileDirectory {
+		// Links for another directory would point at the wrong files.
+		st = fileLinkState{Dir: cfg.FileDirectory, Links: []FileLink{}}
+	}
+	if shared {
+		for _, l := range st.Links {
+			if l.Shared && l.Path == rel && l.Downloads == 0 && l.MaxDownloads == opts.MaxDownloads &&
+				l.Active(now) && l.ExpiresAt.Sub(now) >= opts.TTL/2 {
+				return l, nil
+			}
+		}
+	}
+	st.Links = append(st.Links, link)
+	if err := writeFileLinks(st); err != nil {
+		return FileLink{}, err
+	}
+	return link, nil
+}
+
+// ListFileLinks returns the links minted for the file server directory of
+// cfg, newest first.
+func ListFileLinks(cfg Config) ([]FileLink, error) {
+	cfg = SanitizeConfig(cfg)
+	fileLinksMu.Lock()
+	defer fileLinksMu.Unlock()
+	st, err := readFileLinks()
+	if err != nil || st.Dir != cfg.FileDirectory {
+		return []FileLink{}, err
+	}
+	links := []FileLink{}
+	for i := len(st.Links) - 1; i >= 0; i-- {
+		if time.Since(st.Links[i].ExpiresAt) < fileLinkRetention {
+			links = append(links, st.Links[i])
+		}
+	}
+	return links, nil
+}
+
+// RevokeFileLink disables a link before it expires.
+func RevokeFileLink(token string) error {
+	fileLinksMu.Lock()
+	defer fileLinksMu.Unlock()
+	st, err := readFileLinks()
+	if err != nil {
+		return err
+	}
+	for i := range st.Links {
+		if st.Links[i].Token == token {
+			st.Links[i].Revoked = true
+			return writeFileLinks(st)
+		}
+	}
+	return fmt.Errorf("unknown link %q", token)
+}
+
+// ClaimFileLink checks a request for token against the link's limits and
+// returns the absolute path of its file under root. A GET counts as a
+// download when it starts, unless it continues the last one (see
+// FileLink.continues): resumed, BITS and certutil fetches use one download.
+func ClaimFileLink(root, token string, r *http.Request) (FileLink, string, error) {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	now := time.Now()
+
+	fileLinksMu.Lock()
+	defer fileLinksMu.Unlock()
+	st, err := readFileLinks()
+	if err != nil {
+		return FileLink{}, "", err
+	}
+	if st.Dir != root {
+		return FileLink{}, "", ErrFileLinkInvalid
+	}
+	for i := range st.Links {
+		link := &st.Links[i]
+		if link.Token != token {
+			continue
+		}
+		count := r.Method == http.MethodGet && !link.continues(remote, rangeResumes(r.Header.Get("Range")), now)
+		allowed := link.Active(now)
+		if r.Method != http.MethodGet || !count {
+			allowed = allowed || link.continues(remote, true, now)
+		}
+		if !allowed || !sourceAllowed(link.SourceIP, remote) {
+			return *link, "", ErrFileLinkInvalid
+		}
+		full, err := ResolveFileServerPath(root, link.Path)
+		if err != nil {
+			return *link, "", ErrFileLinkInvalid
+		}
+		if count {
+			link.Downloads++
+			link.LastSource = remote
+			link.LastDownloadAt = now
+			if err := writeFileLinks(st); err != nil {
+				return *link, "", err
+			}
+		}
+		return *link, full, nil
+	}
+	return FileLink{}, "", ErrFileLinkInvalid
+}
+
+// rangeResumes reports whether a Range header only asks for bytes past the
+// start of the file, i.e. continues an earlier download.
+func rangeResumes(header string) bool {
+	specs, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok {
+		return false
+	}
+	for _, spec := range strings.Split(specs, ",") {
+		start, _, _ := strings.Cut(strings.TrimSpace(spec), "-")
+		if n, err := strconv.ParseInt(start, 10, 64); start != "" && (err != nil || n == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// FileLinkURL returns the URL targets use for link. cfg must have its public
+// IP resolved.
+func FileLinkURL(cfg Config, link FileLink) string {
+	return FileServerURL(cfg) + "/d/" + link.Token + "/" + url.PathEscape(path.Base(link.Path))
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func linkRequest(method, remote, rangeHeader string) *http.Request {
+	r := httptest.NewRequest(method, "/d/token/tool.exe", nil)
+	r.RemoteAddr = remote + ":50000"
+	if rangeHeader != "" {
+		r.Header.Set("Range", rangeHeader)
+	}
+	return r
+}
+
+func TestClaimFileLinkCountsDownloadsOnce(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "tool.exe"), "MZ tool")
+	cfg := DefaultConfig()
+	cfg.FileDirectory = root
+	link, err := CreateFileLink(cfg, "tool.exe", FileLinkOptions{MaxDownloads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claim := func(r *http.Request) error {
+		t.Helper()
+		_, _, err := ClaimFileLink(root, link.Token, r)
+		return err
+	}
+	if err := claim(linkRequest(http.MethodHead, "10.0.0.5", "")); err != nil {
+		t.Fatalf("HEAD: %v", err)
+	}
+	if err := claim(linkRequest(http.MethodGet, "10.0.0.5", "bytes=0-99")); err != nil {
+		t.Fatalf("first range: %v", err)
+	}
+	// BITS and resumed downloads continue with later ranges.
+	for _, rng := range []string{"bytes=100-199", "bytes=200-", "bytes=-50"} {
+		if err := claim(linkRequest(http.MethodGet, "10.0.0.5", rng)); err != nil {
+			t.Errorf("continuation %s: %v", rng, err)
+		}
+	}
+	// certutil -urlcache fetches the whole file twice.
+	if err := claim(linkRequest(http.MethodGet, "10.0.0.5", "")); err != nil {
+		t.Errorf("refetch: %v", err)
+	}
+	if err := claim(linkRequest(http.MethodGet, "10.0.0.6", "bytes=100-")); !errors.Is(err, ErrFileLinkInvalid) {
+		t.Errorf("continuation from another source = %v", err)
+	}
+
+	links, _ := ListFileLinks(cfg)
+	if len(links) != 1 || links[0].Downloads != 1 || links[0].LastSource != "10.0.0.5" {
+		t.Fatalf("links = %+v, want one download", links)
+	}
+
+	// Once the refetch window passes, a new full download is refused.
+	fileLinksMu.Lock()
+	st, _ := readFileLinks()
+	st.Links[0].LastDownloadAt = time.Now().Add(-fileLinkRefetchWindow)
+	writeFileLinks(st)
+	fileLinksMu.Unlock()
+	if err := claim(linkRequest(http.MethodGet, "10.0.0.5", "")); !errors.Is(err, ErrFileLinkInvalid) {
+		t.Errorf("second download = %v", err)
+	}
+	if err := claim(linkRequest(http.MethodGet, "10.0.0.5", "bytes=0-")); !errors.Is(err, ErrFileLinkInvalid) {
+		t.Errorf("second download from byte 0 = %v", err)
+	}
+	if err := claim(linkRequest(http.MethodGet, "10.0.0.5", "bytes=300-")); err != nil {
+		t.Errorf("late resume: %v", err)
+	}
+
+	if err := RevokeFileLink(link.Token); err != nil {
+		t.Fatal(err)
+	}
+	if err := claim(linkRequest(http.MethodGet, "10.0.0.5", "bytes=300-")); !errors.Is(err, ErrFileLinkInvalid) {
+		t.Errorf("resume of a revoked link = %v", err)
+	}
+}
+
+func TestRangeResumes(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                  false,
+		"bytes=0-":          false,
+		"bytes=0-99":        false,
+		"bytes=100-199":     true,
+		"bytes=-500":        true,
+		"bytes=100-,0-10":   false,
+		"bytes=100-,200-10": true,
+		"items=5-":          false,
+	} {
+		if got := rangeResumes(header); got != want {
+			t.Errorf("rangeResumes(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestSharedFileLinkReused(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "tool.exe"), "MZ tool")
+	writeTestFile(t, filepath.Join(root, "other.exe"), "MZ other")
+	cfg := DefaultConfig()
+	cfg.PublicIP = "10.10.14.2"
+	cfg.FileDirectory = root
+	cfg.FileLinksOnly = true
+
+	first, err := FileDownloadCommand(cfg, "windows", "tool.exe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := FileDownloadCommand(cfg, "linux", "tool.exe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Link.Token != first.Link.Token {
+		t.Errorf("refreshing the command minted a new link")
+	}
+	other, _ := SharedFileLink(cfg, "other.exe")
+	explicit, _ := FileDownloadCommand(cfg, "linux", "tool.exe", &FileLinkOptions{})
+	if other.Token == first.Link.Token || explicit.Link.Token == first.Link.Token {
+		t.Error("a link was shared with another file or an explicit request")
+	}
+	if links, _ := ListFileLinks(cfg); len(links) != 3 {
+		t.Errorf("%d links minted, want 3", len(links))
+	}
+
+	// Once downloaded, the next command gets a fresh link.
+	if _, _, err := ClaimFileLink(root, first.Link.Token, linkRequest(http.MethodGet, "10.0.0.5", "")); err != nil {
+		t.Fatal(err)
+	}
+	next, _ := SharedFileLink(cfg, "tool.exe")
+	if next.Token == first.Link.Token {
+		t.Error("a downloaded link was reused")
+	}
+
+	// So does one past half its lifetime.
+	fileLinksMu.Lock()
+	st, _ := readFileLinks()
+	for i := range st.Links {
+		if st.Links[i].Token == next.Token {
+			st.Links[i].ExpiresAt = time.Now().Add(10 * time.Minute)
+		}
+	}
+	writeFileLinks(st)
+	fileLinksMu.Unlock()
+	if last, _ := SharedFileLink(cfg, "tool.exe"); last.Token == next.Token {
+		t.Error("a link about to expire was reused")
+	}
+}
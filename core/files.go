@@ -2,64 +2,367 @@ package core
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 )
 
+// ErrOutsideFileRoot is returned for paths that leave the file server
+// directory, directly or through a symlink.
+var ErrOutsideFileRoot = errors.New("path is outside the file server directory")
+
 type FileEntry struct {
-	Name    string    `json:"name"`
+	Name string `json:"name"`
+	// Path is relative to the file server directory, with forward slashes.
+	Path    string    `json:"path"`
 	Size    int64     `json:"size"`
 	ModTime time.Time `json:"mod_time"`
 	IsDir   bool      `json:"is_dir"`
 }
 
-// ListFileServerDir returns a flat list of files in the configured file server directory (non-recursive).
-func ListFileServerDir() ([]FileEntry, error) {
+// Breadcrumb is one level of the path to a listed directory.
+type Breadcrumb struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// FileListing is the content of one directory under the file server root.
+type FileListing struct {
+	Path        string       `json:"path"`
+	Breadcrumbs []Breadcrumb `json:"breadcrumbs"`
+	Entries     []FileEntry  `json:"entries"`
+}
+
+// CleanFileServerPath normalizes a path relative to the file server root to
+// forward slashes without leading or trailing slashes. "" is the root. Paths
+// with ".." segments are rejected rather than cleaned, so a bad link fails
+// instead of pointing somewhere else.
+func CleanFileServerPath(rel string) (string, error) {
+	rel = strings.ReplaceAll(strings.TrimSpace(rel), `\`, "/")
+	rel = strings.Trim(rel, "/")
+	if rel == "" {
+		return "", nil
+	}
+	for _, seg := range strings.Split(rel, "/") {
+		if seg == ".." {
+			return "", ErrOutsideFileRoot
+		}
+		if strings.ContainsRune(seg, 0) {
+			return "", errors.New("invalid path")
+		}
+	}
+	return path.Clean(rel), nil
+}
+
+// ResolveFileServerPath returns the absolute path of rel under root after
+// resolving symlinks, and fails if the result is outside root.
+func ResolveFileServerPath(root, rel string) (string, error) {
+	rel, err := CleanFileServerPath(rel)
+	if err != nil {
+		return "", err
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(filepath.Join(realRoot, filepath.FromSlash(rel)))
+	if err != nil {
+		return "", err
+	}
+	if !withinDir(realRoot, real) {
+		return "", ErrOutsideFileRoot
+	}
+	return real, nil
+}
+
+func withinDir(dir, p string) bool {
+	r, err := filepath.Rel(dir, p)
+	return err == nil && r != ".." && !strings.HasPrefix(r, ".."+string(filepath.Separator)) && !filepath.IsAbs(r)
+}
+
+// FileServerFS serves root like http.Dir, but resolves every path with
+// ResolveFileServerPath so symlinks cannot reach files outside root. Such
+// paths, and directory entries linking outside root, look like they do not
+// exist.
+func FileServerFS(root string) http.FileSystem {
+	return fileServerFS{root: root}
+}
+
+type fileServerFS struct {
+	root string
+}
+
+func (fsys fileServerFS) Open(name string) (http.File, error) {
+	full, err := ResolveFileServerPath(fsys.root, name)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		return nil, err
+	}
+	realRoot, err := filepath.EvalSymlinks(fsys.root)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fileServerFile{File: f, dir: full, realRoot: realRoot}, nil
+}
+
+// fileServerFile hides directory entries that link outside the root. Only
+// the http.File methods are exposed, so http.FileServer lists directories
+// through Readdir.
+type fileServerFile struct {
+	http.File
+	dir      string
+	realRoot string
+}
+
+func (f fileServerFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	kept := infos[:0]
+	for _, fi := range infos {
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(filepath.Join(f.dir, fi.Name()))
+			if err != nil || !withinDir(f.realRoot, target) {
+				continue
+			}
+		}
+		kept = append(kept, fi)
+	}
+	return kept, err
+}
+
+func fileServerRoot() (string, error) {
 	cfg, err := LoadConfig()
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return nil, errors.New("file server directory not configured")
+			return "", errors.New("file server directory not configured")
 		}
-		return nil, err
+		return "", err
 	}
 	cfg = SanitizeConfig(cfg)
 
 	root := cfg.FileDirectory
 	if root == "" {
-		return nil, errors.New("file server directory not configured")
+		return "", errors.New("file server directory not configured")
 	}
-
 	fi, err := os.Stat(root)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	if !fi.IsDir() {
-		return nil, errors.New("file server directory path is not a directory")
+		return "", errors.New("file server directory path is not a directory")
 	}
+	return root, nil
+}
 
-	entries := []FileEntry{}
-	dirEntries, err := os.ReadDir(root)
+// ListFileServerDir lists the directory rel (relative to the configured file
+// server directory, "" for the root). Symlinks leading outside the root are
+// left out.
+func ListFileServerDir(rel string) (FileListing, error) {
+	listing := FileListing{Breadcrumbs: []Breadcrumb{{Name: "/", Path: ""}}, Entries: []FileEntry{}}
+	root, err := fileServerRoot()
 	if err != nil {
-		return nil, err
+		return listing, err
+	}
+	rel, err = CleanFileServerPath(rel)
+	if err != nil {
+		return listing, err
+	}
+	dir, err := ResolveFileServerPath(root, rel)
+	if err != nil {
+		return listing, err
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		return listing, err
+	} else if !fi.IsDir() {
+		return listing, fmt.Errorf("%s is not a directory", rel)
 	}
 
+	listing.Path = rel
+	if rel != "" {
+		parts := strings.Split(rel, "/")
+		for i, name := range parts {
+			listing.Breadcrumbs = append(listing.Breadcrumbs, Breadcrumb{Name: name, Path: strings.Join(parts[:i+1], "/")})
+		}
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return listing, err
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return listing, err
+	}
 	for _, de := range dirEntries {
-		info, err := de.Info()
+		full := filepath.Join(dir, de.Name())
+		if de.Type()&os.ModeSymlink != 0 {
+			if target, err := filepath.EvalSymlinks(full); err != nil || !withinDir(realRoot, target) {
+				continue
+			}
+		}
+		// Stat follows symlinks so linked directories can be browsed.
+		info, err := os.Stat(full)
 		if err != nil {
 			continue
 		}
-		entries = append(entries, FileEntry{
+		listing.Entries = append(listing.Entries, FileEntry{
 			Name:    de.Name(),
+			Path:    path.Join(rel, de.Name()),
 			Size:    info.Size(),
 			ModTime: info.ModTime(),
-			IsDir:   de.IsDir(),
+			IsDir:   info.IsDir(),
 		})
 	}
 
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].Name < entries[j].Name
+	// Directories first, then by name.
+	sort.Slice(listing.Entries, func(i, j int) bool {
+		a, b := listing.Entries[i], listing.Entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		return a.Name < b.Name
 	})
 
-	return entries, nil
+	return listing, nil
+}
+
+// ValidateFileCommand checks the target OS and the path of a download
+// one-liner, relative to the file server directory.
+func ValidateFileCommand(osName, filename string) error {
+	if osName != "linux" && osName != "windows" {
+		return errors.New("invalid os")
+	}
+	rel, err := CleanFileServerPath(filename)
+	if err != nil || rel == "" {
+		return errors.New("invalid filename")
+	}
+	return nil
+}
+
+// FileCommand is a download one-liner and the URL it fetches.
+type FileCommand struct {
+	Command string    `json:"command"`
+	URL     string    `json:"url"`
+	Link    *FileLink `json:"link,omitempty"`
+}
+
+// FileDownloadCommand returns a one-liner fetching filename (a path relative
+// to the file server directory) for use on a target running osName. The file
+// is saved under its base name. With link set a tokenized link is minted and
+// used instead of the plain URL; in FileLinksOnly mode a shared link is used
+// (see SharedFileLink). It fails if the file escapes the file server
+// directory or PublicIP names an interface without an address.
+func FileDownloadCommand(cfg Config, osName, filename string, link *FileLinkOptions) (FileCommand, error) {
+	if err := ValidateFileCommand(osName, filename); err != nil {
+		return FileCommand{}, err
+	}
+	rel, _ := CleanFileServerPath(filename)
+	cfg = SanitizeConfig(cfg)
+	if cfg.FileDirectory != "" {
+		if _, err := ResolveFileServerPath(cfg.FileDirectory, rel); errors.Is(err, ErrOutsideFileRoot) {
+			return FileCommand{}, err
+		}
+	}
+	cfg, err := ResolveCallbackConfig(cfg)
+	if err != nil {
+		return FileCommand{}, err
+	}
+
+	var out FileCommand
+	if link != nil || cfg.FileLinksOnly {
+		var l FileLink
+		if link != nil {
+			l, err = CreateFileLink(cfg, rel, *link)
+		} else {
+			l, err = SharedFileLink(cfg, rel)
+		}
+		if err != nil {
+			return FileCommand{}, err
+		}
+		out.Link = &l
+		out.URL = FileLinkURL(cfg, l)
+	} else {
+		out.URL = fileServerPathURL(cfg, rel)
+	}
+
+	name := path.Base(rel)
+	if osName == "linux" {
+		out.Command = fmt.Sprintf("curl %s-o %s %s", curlTLSFlag(cfg), shellQuote(name), shellQuote(out.URL))
+	} else {
+		out.Command = fmt.Sprintf(`powershell -Command "%sInvoke-WebRequest -Uri %s -OutFile %s"`, psTLSPrefix(cfg), psQuote(out.URL), psQuote(name))
+	}
+	return out, nil
+}
+
+// FileServerURL returns the base URL targets use to reach the file server,
+// e.g. https://10.10.14.2:8000. cfg must have its public IP resolved.
+func FileServerURL(cfg Config) string {
+	scheme := "http"
+	if cfg.FileTLS {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, cfg.PublicIP, cfg.FilePort)
+}
+
+// fileServerPathURL returns the plain URL of rel on the file server, with
+// each path segment escaped.
+func fileServerPathURL(cfg Config, rel string) string {
+	segs := strings.Split(rel, "/")
+	for i, seg := range segs {
+		segs[i] = url.PathEscape(seg)
+	}
+	return FileServerURL(cfg) + "/" + strings.Join(segs, "/")
+}
+
+// curlTLSFlag returns curl's skip-verify flag when the file server uses TLS.
+func curlTLSFlag(cfg Config) string {
+	if cfg.FileTLS {
+		return "-k "
+	}
+	return ""
+}
+
+// psSkipVerify makes Windows PowerShell accept any server certificate and
+// use TLS 1.2, which older .NET versions do not enable by default.
+const psSkipVerify = `[Net.ServicePointManager]::ServerCertificateValidationCallback={$true};[Net.ServicePointManager]::SecurityProtocol='Tls12'; `
+
+// psTLSPrefix returns psSkipVerify when the file server uses TLS.
+func psTLSPrefix(cfg Config) string {
+	if cfg.FileTLS {
+		return psSkipVerify
+	}
+	return ""
+}
+
+// shellQuote quotes s for a POSIX shell, leaving plain words as they are.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// cmdQuote double-quotes s for cmd.exe when needed. Windows file names can't
+// hold a double quote.
+func cmdQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:\\") == "" {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, "") + `"`
+}
+
+// psQuote single-quotes s for PowerShell inside a double-quoted -Command.
+func psQuote(s string) string {
+	s = strings.ReplaceAll(s, "'", "''")
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return "'" + s + "'"
 }
@@ -0,0 +1,201 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCleanFileServerPath(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "", want: ""},
+		{in: "/", want: ""},
+		{in: "agent.exe", want: "agent.exe"},
+		{in: "/tools/linux/agent/", want: "tools/linux/agent"},
+		{in: `tools\windows\agent.exe`, want: "tools/windows/agent.exe"},
+		{in: "tools//./agent", want: "tools/agent"},
+		{in: "  agent  ", want: "agent"},
+		{in: "..", wantErr: true},
+		{in: "../etc/passwd", wantErr: true},
+		{in: "tools/../../etc/passwd", wantErr: true},
+		{in: `..\..\windows\win.ini`, wantErr: true},
+		{in: `tools\..\agent`, wantErr: true},
+		{in: "bad\x00name", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := CleanFileServerPath(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("CleanFileServerPath(%q) = %q, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("CleanFileServerPath(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// fileServerTree creates a file server root with a nested file, a symlink
+// inside the root and symlinks escaping it, plus a secret file outside.
+func fileServerTree(t *testing.T) (root, outside string) {
+	t.Helper()
+	base := t.TempDir()
+	root = filepath.Join(base, "loot")
+	outside = filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "tools", "linux"), outside} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, filepath.Join(root, "tools", "linux", "agent"), "agent")
+	writeTestFile(t, filepath.Join(outside, "secret"), "secret")
+	for link, target := range map[string]string{
+		filepath.Join(root, "linked"):       filepath.Join(root, "tools"),
+		filepath.Join(root, "escape"):       outside,
+		filepath.Join(root, "escape-file"):  filepath.Join(outside, "secret"),
+		filepath.Join(root, "tools", "top"): "/",
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	return root, outside
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveFileServerPath(t *testing.T) {
+	root, _ := fileServerTree(t)
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rel  string
+		want string
+		err  error
+	}{
+		{rel: "", want: realRoot},
+		{rel: "tools/linux/agent", want: filepath.Join(realRoot, "tools", "linux", "agent")},
+		{rel: `tools\linux\agent`, want: filepath.Join(realRoot, "tools", "linux", "agent")},
+		{rel: "linked/linux/agent", want: filepath.Join(realRoot, "tools", "linux", "agent")},
+		{rel: "escape/secret", err: ErrOutsideFileRoot},
+		{rel: "escape-file", err: ErrOutsideFileRoot},
+		{rel: "tools/top/etc/passwd", err: ErrOutsideFileRoot},
+		{rel: "../outside/secret", err: ErrOutsideFileRoot},
+		{rel: `..\outside\secret`, err: ErrOutsideFileRoot},
+		{rel: "missing", err: os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		got, err := ResolveFileServerPath(root, tt.rel)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ResolveFileServerPath(%q) = %q, %v; want %v", tt.rel, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveFileServerPath(%q) = %q, %v; want %q", tt.rel, got, err, tt.want)
+		}
+	}
+}
+
+func TestFileServerFSSymlinkEscape(t *testing.T) {
+	root, _ := fileServerTree(t)
+	srv := httptest.NewServer(http.FileServer(FileServerFS(root)))
+	defer srv.Close()
+
+	get := func(p string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	for _, p := range []string{"/tools/linux/agent", "/linked/linux/agent"} {
+		if status, body := get(p); status != http.StatusOK || body != "agent" {
+			t.Errorf("GET %s = %d %q, want 200 agent", p, status, body)
+		}
+	}
+	for _, p := range []string{"/escape/secret", "/escape-file", "/escape/", "/tools/top/etc/passwd", "/tools/top/"} {
+		if status, body := get(p); status != http.StatusNotFound {
+			t.Errorf("GET %s = %d %q, want 404", p, status, body)
+		}
+	}
+
+	status, body := get("/")
+	if status != http.StatusOK {
+		t.Fatalf("GET / = %d", status)
+	}
+	if !strings.Contains(body, "linked") || !strings.Contains(body, "tools") {
+		t.Errorf("root listing misses entries inside the root:\n%s", body)
+	}
+	if strings.Contains(body, "escape") {
+		t.Errorf("root listing shows links escaping the root:\n%s", body)
+	}
+	if _, body := get("/tools/"); strings.Contains(body, "top") {
+		t.Errorf("tools listing shows a link escaping the root:\n%s", body)
+	}
+}
+
+func TestFileCommandsSkipVerifyOverHTTPS(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := DefaultConfig()
+	cfg.PublicIP = "10.10.14.2"
+	cfg.FileUpload = true
+	cfg.FileUploadToken = "secret"
+
+	for _, https := range []bool{false, true} {
+		cfg.FileTLS = https
+		scheme := map[bool]string{false: "http://", true: "https://"}[https]
+		check := func(what, cmd, flag string) {
+			t.Helper()
+			if strings.Contains(cmd, flag) != https || !strings.Contains(cmd, scheme+"10.10.14.2:8000/") {
+				t.Errorf("%s over https=%v: %s", what, https, cmd)
+			}
+		}
+
+		linux, err := FileDownloadCommand(cfg, "linux", "tools/tool", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check("linux download", linux.Command, "curl -k ")
+		windows, _ := FileDownloadCommand(cfg, "windows", "tools/tool.exe", nil)
+		check("windows download", windows.Command, `powershell -Command "`+psSkipVerify+"Invoke-WebRequest")
+
+		uploads, err := FileUploadCommands(cfg, `C:\loot.txt`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, up := range uploads {
+			flag := "curl -k "
+			switch up.Tool {
+			case "powershell":
+				flag = psSkipVerify
+			case "certutil":
+				flag = "curl.exe -k "
+			}
+			check(up.Tool+" upload", up.Command, flag)
+		}
+	}
+}
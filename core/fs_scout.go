@@ -2,10 +2,10 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
@@ -49,7 +49,9 @@ type FSScoutResult struct {
 	Error      string `json:"error,omitempty"`
 }
 
-func RunFSScout(req FSScoutRequest) (FSScoutResult, error) {
+// RunFSScout lists files on a remote host. Cancelling ctx stops the
+// underlying ssh/smbclient/evil-winrm process.
+func RunFSScout(ctx context.Context, req FSScoutRequest) (FSScoutResult, error) {
 	if req.Host == "" {
 		return FSScoutResult{}, errors.New("host is required")
 	}
@@ -83,13 +85,13 @@ func RunFSScout(req FSScoutRequest) (FSScoutResult, error) {
 	var runErr error
 	switch req.Protocol {
 	case FSProtocolSSH:
-		runErr = runFSScoutSSH(req, outPath)
+		runErr = runFSScoutSSH(ctx, req, outPath)
 	case FSProtocolSMB:
-		runErr = runFSScoutSMB(req, outPath)
+		runErr = runFSScoutSMB(ctx, req, outPath)
 	case FSProtocolFTP:
 		runErr = errors.New("FTP auto-scout not implemented yet; use generate-only / manual mode")
 	case FSProtocolEvilWinRM:
-		runErr = runFSScoutEvilWinRM(req, outPath)
+		runErr = runFSScoutEvilWinRM(ctx, req, outPath)
 	default:
 		runErr = errors.New("unsupported protocol")
 	}
@@ -115,7 +117,7 @@ func sanitizeHost(h string) string {
 	return h
 }
 
-func runFSScoutSSH(req FSScoutRequest, outPath string) error {
+func runFSScoutSSH(ctx context.Context, req FSScoutRequest, outPath string) error {
 	port := req.Port
 	if port == 0 {
 		port = 22
@@ -132,7 +134,7 @@ func runFSScoutSSH(req FSScoutRequest, outPath string) error {
 		"-printf", "%p\n",
 	}
 
-	cmd := exec.Command("ssh", args...)
+	cmd := newCommand(ctx, "ssh", args...)
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
@@ -169,7 +171,7 @@ func writeFSScoutOutputSSH(outPath, stdout, stderr string) error {
 	return os.WriteFile(outPath, buf.Bytes(), 0o644)
 }
 
-func runFSScoutSMB(req FSScoutRequest, outPath string) error {
+func runFSScoutSMB(ctx context.Context, req FSScoutRequest, outPath string) error {
 	if req.SMBShare == "" {
 		return errors.New("SMB share name is required for smb protocol")
 	}
@@ -183,7 +185,7 @@ func runFSScoutSMB(req FSScoutRequest, outPath string) error {
 		"-c", "recurse; ls",
 	}
 
-	cmd := exec.Command("smbclient", args...)
+	cmd := newCommand(ctx, "smbclient", args...)
 	var outBuf bytes.Buffer
 	cmd.Stdout = &outBuf
 	cmd.Stderr = &outBuf
@@ -227,7 +229,7 @@ func parseAndWriteSMBOutput(outPath, raw string) error {
 	return os.WriteFile(outPath, buf.Bytes(), 0o644)
 }
 
-func runFSScoutEvilWinRM(req FSScoutRequest, outPath string) error {
+func runFSScoutEvilWinRM(ctx context.Context, req FSScoutRequest, outPath string) error {
 	port := req.Port
 	if port == 0 {
 		port = 5985
@@ -262,7 +264,7 @@ Walk $start 0
 		"-c", psScript,
 	}
 
-	cmd := exec.Command("evil-winrm", args...)
+	cmd := newCommand(ctx, "evil-winrm", args...)
 	var outBuf bytes.Buffer
 	cmd.Stdout = &outBuf
 	cmd.Stderr = &outBuf
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// NetInterface is a local network interface and its addresses.
+type NetInterface struct {
+	Name     string `json:"name"`
+	Up       bool   `json:"up"`
+	Loopback bool   `json:"loopback"`
+	// Tunnel marks point-to-point and tun/tap/wg/ppp interfaces, i.e. the
+	// usual VPN callback interfaces.
+	Tunnel bool     `json:"tunnel"`
+	Addrs  []string `json:"addrs"` // CIDR notation
+	// Callback is the address used when PublicIP names this interface.
+	Callback string `json:"callback,omitempty"`
+}
+
+var tunnelIfacePrefixes = []string{"tun", "tap", "wg", "ppp", "utun", "tailscale", "nordlynx"}
+
+// ListInterfaces enumerates local interfaces, VPN-style interfaces first.
+func ListInterfaces() ([]NetInterface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []NetInterface{}
+	for _, iface := range ifaces {
+		ni := NetInterface{
+			Name:     iface.Name,
+			Up:       iface.Flags&net.FlagUp != 0,
+			Loopback: iface.Flags&net.FlagLoopback != 0,
+			Tunnel:   iface.Flags&net.FlagPointToPoint != 0 || hasTunnelPrefix(iface.Name),
+			Addrs:    []string{},
+		}
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			ni.Addrs = append(ni.Addrs, a.String())
+		}
+		ni.Callback = callbackAddr(addrs)
+		out = append(out, ni)
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Tunnel != out[j].Tunnel {
+			return out[i].Tunnel
+		}
+		if out[i].Loopback != out[j].Loopback {
+			return out[j].Loopback
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+func hasTunnelPrefix(name string) bool {
+	for _, p := range tunnelIfacePrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// callbackAddr picks the first IPv4 address, falling back to a global IPv6
+// address. Link-local addresses are never used.
+func callbackAddr(addrs []net.Addr) string {
+	var v6 string
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() || ipNet.IP.IsUnspecified() {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			return ip4.String()
+		}
+		if v6 == "" && ipNet.IP.IsGlobalUnicast() {
+			v6 = ipNet.IP.String()
+		}
+	}
+	return v6
+}
+
+// PublicIPIfacePrefix marks a PublicIP naming a local interface, e.g.
+// "iface:tun0", rather than a literal IP or domain.
+const PublicIPIfacePrefix = "iface:"
+
+// PublicIPInterface returns the interface name when publicIP is
+// "iface:<name>".
+func PublicIPInterface(publicIP string) (string, bool) {
+	name, ok := strings.CutPrefix(strings.TrimSpace(publicIP), PublicIPIfacePrefix)
+	return strings.TrimSpace(name), ok
+}
+
+// ResolvePublicIP returns the callback host for cfg.PublicIP. For
+// "iface:<name>" the interface's current address is returned, so commands
+// follow the VPN address, and a missing interface is an error. Any other
+// value is an IP or domain and is returned as is, without IPv6 brackets.
+func ResolvePublicIP(cfg Config) (string, error) {
+	host := strings.TrimSpace(cfg.PublicIP)
+	name, ok := PublicIPInterface(host)
+	if !ok {
+		if inner := strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"); inner != host && net.ParseIP(inner) != nil {
+			return inner, nil
+		}
+		return host, nil
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", fmt.Errorf("public_ip interface %s not found (is the VPN up?)", name)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("public_ip interface %s: %w", name, err)
+	}
+	addr := callbackAddr(addrs)
+	if addr == "" {
+		return "", fmt.Errorf("public_ip interface %s has no usable address (is the VPN up?)", name)
+	}
+	return addr, nil
+}
+
+// ResolveCallbackConfig returns cfg with PublicIP resolved for use in
+// commands. IPv6 addresses are bracketed so host:port stays valid.
+func ResolveCallbackConfig(cfg Config) (Config, error) {
+	host, err := ResolvePublicIP(cfg)
+	if err != nil {
+		return cfg, err
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+	cfg.PublicIP = host
+	return cfg, nil
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// loopbackName returns the name of a local loopback interface with 127.0.0.1.
+func loopbackName(t *testing.T) string {
+	t.Helper()
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+				return iface.Name
+			}
+		}
+	}
+	t.Skip("no loopback interface with 127.0.0.1")
+	return ""
+}
+
+func TestResolvePublicIP(t *testing.T) {
+	lo := loopbackName(t)
+	tests := []struct {
+		publicIP string
+		want     string
+		wantErr  string
+	}{
+		{publicIP: "10.10.14.2", want: "10.10.14.2"},
+		{publicIP: "c2.example.com", want: "c2.example.com"},
+		{publicIP: "dead:beef::2", want: "dead:beef::2"},
+		{publicIP: "[dead:beef::2]", want: "dead:beef::2"},
+		{publicIP: PublicIPIfacePrefix + lo, want: "127.0.0.1"},
+		// A name without the prefix is a host, even if it is an interface.
+		{publicIP: lo, want: lo},
+		{publicIP: "tunnelbox", want: "tunnelbox"},
+		{publicIP: "iface:potg-missing0", wantErr: "not found (is the VPN up?)"},
+	}
+	for _, tt := range tests {
+		got, err := ResolvePublicIP(Config{PublicIP: tt.publicIP})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ResolvePublicIP(%q) = %q, %v; want error %q", tt.publicIP, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ResolvePublicIP(%q) = %q, %v; want %q", tt.publicIP, got, err, tt.want)
+		}
+	}
+}
+
+func TestResolveCallbackConfig(t *testing.T) {
+	cfg, err := ResolveCallbackConfig(Config{PublicIP: "dead:beef::2"})
+	if err != nil || cfg.PublicIP != "[dead:beef::2]" {
+		t.Fatalf("ResolveCallbackConfig = %q, %v", cfg.PublicIP, err)
+	}
+	if _, err := ResolveCallbackConfig(Config{PublicIP: "iface:potg-missing0"}); err == nil {
+		t.Error("ResolveCallbackConfig with a missing interface succeeded")
+	}
+
+	// A resolved config is safe to pass on: the bracketed address is still
+	// an IP SAN, not a DNS name.
+	dnsNames, ips := certNames(cfg, "0.0.0.0")
+	if slices.ContainsFunc(dnsNames, func(n string) bool { return strings.Contains(n, ":") }) {
+		t.Errorf("certNames DNS names = %v", dnsNames)
+	}
+	if !slices.ContainsFunc(ips, net.ParseIP("dead:beef::2").Equal) {
+		t.Errorf("certNames IPs = %v, want dead:beef::2", ips)
+	}
+}
+
+func TestDecodeConfigKeepsPublicIP(t *testing.T) {
+	// Baseline configs have no schema_version; a bare name stays a host.
+	for _, host := range []string{"tun0", "wgserver", "c2.example.com"} {
+		cfg, _, err := decodeConfig([]byte(`{"public_ip": "` + host + `"}`))
+		if err != nil || cfg.PublicIP != host {
+			t.Errorf("decodeConfig public_ip %q = %q, %v", host, cfg.PublicIP, err)
+		}
+	}
+}
+
+func TestValidatePublicIPInterface(t *testing.T) {
+	for value, ok := range map[string]bool{
+		"iface:tun0":       true,
+		"iface:Ethernet 2": true,
+		"iface:":           false,
+		"iface:a/b":        false,
+	} {
+		cfg := DefaultConfig()
+		cfg.PublicIP = value
+		errs := ValidateConfig(cfg).Only(map[string]bool{"public_ip": true})
+		if (len(errs) == 0) != ok {
+			t.Errorf("ValidateConfig(public_ip=%q) = %v", value, errs)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LigoloAPIClient talks to the web/REST API of a running ligolo-ng proxy
+// (v0.7+). The API is enabled through the config written by
+// WriteLigoloProxyConfig. A session token is obtained on first use and
+// refreshed once when the proxy answers 401.
+type LigoloAPIClient struct {
+	baseURL  string
+	user     string
+	password string
+	http     *http.Client
+
+	mu    sync.Mutex
+	token string
+}
+
+// LigoloAgent is an agent connected to the proxy. Ligolo calls a connected
+// agent a session; its ID selects the session for tunnel and listener calls.
+type LigoloAgent struct {
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	RemoteAddr string   `json:"remote_addr"`
+	SessionID  string   `json:"session_id"`
+	Interface  string   `json:"interface"`
+	Running    bool     `json:"running"`
+	Networks   []string `json:"networks"`
+}
+
+// LigoloListener is a port forward from an agent back to the proxy side.
+type LigoloListener struct {
+	ID           int    `json:"id"`
+	AgentID      int    `json:"agent_id"`
+	Agent        string `json:"agent"`
+	Network      string `json:"network"`
+	ListenerAddr string `json:"listener_addr"`
+	RedirectAddr string `json:"redirect_addr"`
+	Online       bool   `json:"online"`
+}
+
+// LigoloInterface is a tun interface known to the proxy.
+type LigoloInterface struct {
+	Name   string   `json:"name"`
+	Active bool     `json:"active"`
+	Routes []string `json:"routes"`
+}
+
+// NewLigoloAPIClient returns a client for the API at baseURL, e.g.
+// http://127.0.0.1:11602.
+func NewLigoloAPIClient(baseURL, user, password string) *LigoloAPIClient {
+	return &LigoloAPIClient{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		user:     user,
+		password: password,
+		http:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// LigoloAPIFromConfig returns a client for the API of the proxy started with
+// cfg, or an error if the API is disabled.
+func LigoloAPIFromConfig(cfg Config) (*LigoloAPIClient, error) {
+	cfg = SanitizeConfig(cfg)
+	if !cfg.ProxyAPIEnabled {
+		return nil, errors.New("ligolo proxy API is disabled (set proxy_api_enabled)")
+	}
+	return NewLigoloAPIClient("http://"+cfg.ProxyAPIAddr, cfg.ProxyAPIUser, cfg.ProxyAPIPassword), nil
+}
+
+func (c *LigoloAPIClient) login(ctx context.Context) (string, error) {
+	body := map[string]string{"username": c.user, "password": c.password}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := c.send(ctx, http.MethodPost, "/api/auth", "", body, &resp); err != nil {
+		return "", fmt.Errorf("ligolo API login failed: %w", err)
+	}
+	if resp.Token == "" {
+		return "", errors.New("ligolo API login failed: no token returned")
+	}
+	return resp.Token, nil
+}
+
+// do performs an authenticated request, logging in again once if the token
+// has expired (the proxy regenerates its signing secret on restart).
+func (c *LigoloAPIClient) do(ctx context.Context, method, path string, in, out interface{}) error {
+	c.mu.Lock()
+	token := c.token
+	c.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if token == "" {
+			t, err := c.login(ctx)
+			if err != nil {
+				return err
+			}
+			token = t
+			c.mu.Lock()
+			c.token = t
+			c.mu.Unlock()
+		}
+
+		err := c.send(ctx, method, path, token, in, out)
+		var apiErr *LigoloAPIError
+		if attempt == 0 && errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+			token = ""
+			continue
+		}
+		return err
+	}
+}
+
+// LigoloAPIError is a non-2xx response from the proxy API.
+type LigoloAPIError struct {
+	Status  int
+	Message string
+}
+
+func (e *LigoloAPIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ligolo API: %s", http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("ligolo API: %s", e.Message)
+}
+
+func (c *LigoloAPIClient) send(ctx context.Context, method, path, token string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(data, &e)
+		msg := e.Error
+		if msg == "" {
+			msg = e.Message
+		}
+		return &LigoloAPIError{Status: resp.StatusCode, Message: msg}
+	}
+
+	if out == nil || len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// Agents lists connected agents, ordered by ID.
+func (c *LigoloAPIClient) Agents(ctx context.Context) ([]LigoloAgent, error) {
+	var raw json.RawMessage
+	if err := c.do(ctx, http.MethodGet, "/api/v1/agents", nil, &raw); err != nil {
+		return nil, err
+	}
+
+	type apiAgent struct {
+		Name       string `json:"Name"`
+		RemoteAddr string `json:"RemoteAddr"`
+		SessionID  string `json:"SessionID"`
+		Interface  string `json:"Interface"`
+		Running    bool   `json:"Running"`
+		Network    []struct {
+			Addresses []string `json:"Addresses"`
+		} `json:"Network"`
+	}
+	toAgent := func(id int, a apiAgent) LigoloAgent {
+		agent := LigoloAgent{
+			ID:         id,
+			Name:       a.Name,
+			RemoteAddr: a.RemoteAddr,
+			SessionID:  a.SessionID,
+			Interface:  a.Interface,
+			Running:    a.Running,
+			Networks:   []string{},
+		}
+		for _, n := range a.Network {
+			agent.Networks = append(agent.Networks, n.Addresses...)
+		}
+		return agent
+	}
+
+	agents := []LigoloAgent{}
+	// The proxy returns agents keyed by ID; older builds return a list.
+	var byID map[string]apiAgent
+	if err := json.Unmarshal(raw, &byID); err == nil {
+		for k, a := range byID {
+			id, err := strconv.Atoi(k)
+			if err != nil {
+				continue
+			}
+			agents = append(agents, toAgent(id, a))
+		}
+	} else {
+		var list []apiAgent
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, fmt.Errorf("unexpected agents response: %w", err)
+		}
+		for i, a := range list {
+			agents = append(agents, toAgent(i, a))
+		}
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+	return agents, nil
+}
+
+// StartTunnel starts relaying the agent's traffic through the named tun
+// interface, creating the interface if it doesn't exist yet.
+func (c *LigoloAPIClient) StartTunnel(ctx context.Context, agentID int, iface string) error {
+	if iface == "" {
+		return errors.New("interface name is required")
+	}
+	ifaces, err := c.Interfaces(ctx)
+	if err != nil {
+		return err
+	}
+	exists := false
+	for _, i := range ifaces {
+		if i.Name == iface {
+			exists = true
+			break
+		}
+	}
+	if !exists {
+		if err := c.CreateInterface(ctx, iface); err != nil {
+			return err
+		}
+	}
+	body := map[string]string{"interface": iface}
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/api/v1/tunnel/%d", agentID), body, nil)
+}
+
+// StopTunnel stops the agent's tunnel.
+func (c *LigoloAPIClient) StopTunnel(ctx context.Context, agentID int) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/api/v1/tunnel/%d", agentID), nil, nil)
+}
+
+// Interfaces lists the proxy's tun interfaces, ordered by name.
+func (c *LigoloAPIClient) Interfaces(ctx context.Context) ([]LigoloInterface, error) {
+	var raw map[string]struct {
+		Active bool `json:"Active"`
+		Routes []struct {
+			Destination string `json:"Destination"`
+		} `json:"Routes"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v1/interfaces", nil, &raw); err != nil {
+		return nil, err
+	}
+	ifaces := []LigoloInterface{}
+	for name, i := range raw {
+		li := LigoloInterface{Name: name, Active: i.Active, Routes: []string{}}
+		for _, r := range i.Routes {
+			li.Routes = append(li.Routes, r.Destination)
+		}
+		ifaces = append(ifaces, li)
+	}
+	sort.Slice(ifaces, func(i, j int) bool { return ifaces[i].Name < ifaces[j].Name })
+	return ifaces, nil
+}
+
+// CreateInterface creates a tun interface on the proxy host.
+func (c *LigoloAPIClient) CreateInterface(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/interfaces", map[string]string{"interface": name}, nil)
+}
+
+// Listeners lists agent listeners.
+func (c *LigoloAPIClient) Listeners(ctx context.Context) ([]LigoloListener, error) {
+	var raw []struct {
+		ListenerID   int    `json:"ListenerID"`
+		AgentID      int    `json:"AgentID"`
+		Agent        string `json:"Agent"`
+		Network      string `json:"Network"`
+		ListenerAddr string `json:"ListenerAddr"`
+		RedirectAddr string `json:"RedirectAddr"`
+		Online       bool   `json:"Online"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v1/listeners", nil, &raw); err != nil {
+		return nil, err
+	}
+	listeners := make([]LigoloListener, 0, len(raw))
+	for _, l := range raw {
+		listeners = append(listeners, LigoloListener{
+			ID:           l.ListenerID,
+			AgentID:      l.AgentID,
+			Agent:        l.Agent,
+			Network:      l.Network,
+			ListenerAddr: l.ListenerAddr,
+			RedirectAddr: l.RedirectAddr,
+			Online:       l.Online,
+		})
+	}
+	return listeners, nil
+}
+
+// AddListener makes the agent listen on listenerAddr and forward connections
+// to redirectAddr on the proxy side. network is "tcp" or "udp".
+func (c *LigoloAPIClient) AddListener(ctx context.Context, agentID int, network, listenerAddr, redirectAddr string) error {
+	if network == "" {
+		network = "tcp"
+	}
+	if network != "tcp" && network != "udp" {
+		return fmt.Errorf("invalid listener network %q", network)
+	}
+	if listenerAddr == "" || redirectAddr == "" {
+		return errors.New("listener and redirect addresses are required")
+	}
+	body := map[string]interface{}{
+		"agentId":      agentID,
+		"network":      network,
+		"listenerAddr": listenerAddr,
+		"redirectAddr": redirectAddr,
+	}
+	return c.do(ctx, http.MethodPost, "/api/v1/listeners", body, nil)
+}
+
+// RemoveListener stops a listener on the given agent.
+func (c *LigoloAPIClient) RemoveListener(ctx context.Context, agentID, listenerID int) error {
+	body := map[string]int{"agentId": agentID, "listenerId": listenerID}
+	return c.do(ctx, http.MethodDelete, "/api/v1/listeners", body, nil)
+}
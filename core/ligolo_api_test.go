@@ -0,0 +1,294 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeLigoloAPI mimics the ligolo-ng proxy API: POST /api/auth hands out the
+// current token and /api/v1/* answers 401 to any other token.
+type fakeLigoloAPI struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	token    string
+	logins   int
+	agents   string
+	ifaces   string
+	requests []fakeLigoloRequest
+}
+
+type fakeLigoloRequest struct {
+	Method string
+	Path   string
+	Body   map[string]interface{}
+}
+
+func newFakeLigoloAPI(t *testing.T) (*fakeLigoloAPI, *LigoloAPIClient) {
+	f := &fakeLigoloAPI{
+		t:      t,
+		token:  "token-1",
+		agents: `{}`,
+		ifaces: `{}`,
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, NewLigoloAPIClient(srv.URL+"/", "ligolo", "s3cret")
+}
+
+func (f *fakeLigoloAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body map[string]interface{}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	if r.URL.Path == "/api/auth" {
+		if r.Method != http.MethodPost || body["username"] != "ligolo" || body["password"] != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid credentials"}`))
+			return
+		}
+		f.logins++
+		json.NewEncoder(w).Encode(map[string]string{"token": f.token})
+		return
+	}
+	if r.Header.Get("Authorization") != f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"token expired"}`))
+		return
+	}
+
+	f.requests = append(f.requests, fakeLigoloRequest{Method: r.Method, Path: r.URL.Path, Body: body})
+	switch r.Method + " " + r.URL.Path {
+	case "GET /api/v1/agents":
+		w.Write([]byte(f.agents))
+	case "GET /api/v1/interfaces":
+		w.Write([]byte(f.ifaces))
+	case "GET /api/v1/listeners":
+		w.Write([]byte(`[{"ListenerID":3,"AgentID":1,"Agent":"bob@web01","Network":"tcp","ListenerAddr":"0.0.0.0:4444","RedirectAddr":"127.0.0.1:4444","Online":true}]`))
+	case "POST /api/v1/interfaces", "POST /api/v1/tunnel/1", "DELETE /api/v1/tunnel/1",
+		"POST /api/v1/listeners", "DELETE /api/v1/listeners":
+		w.Write([]byte(`{"message":"ok"}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"no route ` + r.URL.Path + `"}`))
+	}
+}
+
+// calls returns the authenticated requests made so far, as "METHOD path".
+func (f *fakeLigoloAPI) calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := []string{}
+	for _, r := range f.requests {
+		out = append(out, r.Method+" "+r.Path)
+	}
+	return out
+}
+
+func (f *fakeLigoloAPI) lastBody() map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[len(f.requests)-1].Body
+}
+
+func TestLigoloAPIAuth(t *testing.T) {
+	ctx := context.Background()
+	f, c := newFakeLigoloAPI(t)
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Agents(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.logins != 1 {
+		t.Errorf("logged in %d times, want the token reused", f.logins)
+	}
+
+	bad := NewLigoloAPIClient(strings.TrimSuffix(c.baseURL, "/"), "ligolo", "wrong")
+	_, err := bad.Agents(ctx)
+	var apiErr *LigoloAPIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized || !strings.Contains(err.Error(), "invalid credentials") {
+		t.Errorf("login with a wrong password = %v", err)
+	}
+}
+
+func TestLigoloAPIReloginAfter401(t *testing.T) {
+	ctx := context.Background()
+	f, c := newFakeLigoloAPI(t)
+	if _, err := c.Agents(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted proxy signs tokens with a new secret.
+	f.mu.Lock()
+	f.token = "token-2"
+	f.mu.Unlock()
+	if _, err := c.Agents(ctx); err != nil {
+		t.Fatalf("request after the token expired: %v", err)
+	}
+	if f.logins != 2 {
+		t.Errorf("logged in %d times, want 2", f.logins)
+	}
+	if c.token != "token-2" {
+		t.Errorf("client kept token %q", c.token)
+	}
+}
+
+func TestLigoloAPIAgents(t *testing.T) {
+	tests := []struct {
+		name   string
+		agents string
+		want   []LigoloAgent
+	}{
+		{
+			name:   "map",
+			agents: `{"2":{"Name":"alice@db01","RemoteAddr":"10.0.0.9:50000"},"1":{"Name":"bob@web01","RemoteAddr":"10.0.0.5:41000","SessionID":"abc","Interface":"ligolo","Running":true,"Network":[{"Addresses":["10.0.0.5/24","fe80::1/64"]},{"Addresses":["172.16.1.5/16"]}]}}`,
+			want: []LigoloAgent{
+				{ID: 1, Name: "bob@web01", RemoteAddr: "10.0.0.5:41000", SessionID: "abc", Interface: "ligolo", Running: true, Networks: []string{"10.0.0.5/24", "fe80::1/64", "172.16.1.5/16"}},
+				{ID: 2, Name: "alice@db01", RemoteAddr: "10.0.0.9:50000", Networks: []string{}},
+			},
+		},
+		{
+			name:   "list",
+			agents: `[{"Name":"bob@web01","Running":true},{"Name":"alice@db01"}]`,
+			want: []LigoloAgent{
+				{ID: 0, Name: "bob@web01", Running: true, Networks: []string{}},
+				{ID: 1, Name: "alice@db01", Networks: []string{}},
+			},
+		},
+		{
+			name:   "empty",
+			agents: `{}`,
+			want:   []LigoloAgent{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, c := newFakeLigoloAPI(t)
+			f.agents = tt.agents
+			got, err := c.Agents(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("Agents() = %s\nwant %s", gotJSON, wantJSON)
+			}
+		})
+	}
+
+	f, c := newFakeLigoloAPI(t)
+	f.agents = `"nope"`
+	if _, err := c.Agents(context.Background()); err == nil {
+		t.Error("Agents() accepted a malformed response")
+	}
+}
+
+func TestLigoloAPITunnel(t *testing.T) {
+	ctx := context.Background()
+	f, c := newFakeLigoloAPI(t)
+
+	if err := c.StartTunnel(ctx, 1, ""); err == nil {
+		t.Error("StartTunnel without an interface succeeded")
+	}
+
+	if err := c.StartTunnel(ctx, 1, "pivot"); err != nil {
+		t.Fatal(err)
+	}
+	if body := f.lastBody(); body["interface"] != "pivot" {
+		t.Errorf("tunnel body = %v", body)
+	}
+	want := []string{"GET /api/v1/interfaces", "POST /api/v1/interfaces", "POST /api/v1/tunnel/1"}
+	if got := f.calls(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("StartTunnel calls = %v, want %v", got, want)
+	}
+
+	// An existing interface is not created again.
+	f.ifaces = `{"pivot":{"Active":false}}`
+	f.requests = nil
+	if err := c.StartTunnel(ctx, 1, "pivot"); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"GET /api/v1/interfaces", "POST /api/v1/tunnel/1"}
+	if got := f.calls(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("StartTunnel calls = %v, want %v", got, want)
+	}
+
+	f.requests = nil
+	if err := c.StopTunnel(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.calls(); len(got) != 1 || got[0] != "DELETE /api/v1/tunnel/1" {
+		t.Errorf("StopTunnel calls = %v", got)
+	}
+
+	err := c.StopTunnel(ctx, 7)
+	var apiErr *LigoloAPIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound || !strings.Contains(err.Error(), "no route") {
+		t.Errorf("StopTunnel on an unknown agent = %v", err)
+	}
+}
+
+func TestLigoloAPIInterfaces(t *testing.T) {
+	f, c := newFakeLigoloAPI(t)
+	f.ifaces = `{"pivot":{"Active":true,"Routes":[{"Destination":"10.0.0.0/24"},{"Destination":"172.16.0.0/16"}]},"ligolo":{"Active":false}}`
+	got, err := c.Interfaces(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotJSON, _ := json.Marshal(got)
+	want := `[{"name":"ligolo","active":false,"routes":[]},{"name":"pivot","active":true,"routes":["10.0.0.0/24","172.16.0.0/16"]}]`
+	if string(gotJSON) != want {
+		t.Errorf("Interfaces() = %s\nwant %s", gotJSON, want)
+	}
+}
+
+func TestLigoloAPIListeners(t *testing.T) {
+	ctx := context.Background()
+	f, c := newFakeLigoloAPI(t)
+
+	got, err := c.Listeners(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LigoloListener{ID: 3, AgentID: 1, Agent: "bob@web01", Network: "tcp", ListenerAddr: "0.0.0.0:4444", RedirectAddr: "127.0.0.1:4444", Online: true}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("Listeners() = %+v", got)
+	}
+
+	if err := c.AddListener(ctx, 1, "", "0.0.0.0:8443", "127.0.0.1:443"); err != nil {
+		t.Fatal(err)
+	}
+	body := f.lastBody()
+	if body["agentId"] != 1.0 || body["network"] != "tcp" || body["listenerAddr"] != "0.0.0.0:8443" || body["redirectAddr"] != "127.0.0.1:443" {
+		t.Errorf("AddListener body = %v", body)
+	}
+	if err := c.AddListener(ctx, 1, "sctp", "0.0.0.0:1", "127.0.0.1:1"); err == nil {
+		t.Error("AddListener accepted network sctp")
+	}
+	if err := c.AddListener(ctx, 1, "udp", "", "127.0.0.1:53"); err == nil {
+		t.Error("AddListener accepted an empty listener address")
+	}
+
+	if err := c.RemoveListener(ctx, 1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if body := f.lastBody(); body["agentId"] != 1.0 || body["listenerId"] != 3.0 {
+		t.Errorf("RemoveListener body = %v", body)
+	}
+	calls := f.calls()
+	if calls[len(calls)-1] != "DELETE /api/v1/listeners" {
+		t.Errorf("RemoveListener calls = %v", calls)
+	}
+}
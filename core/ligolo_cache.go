@@ -0,0 +1,249 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LigoloCacheDir returns the local release cache used for offline installs.
+// Archives are stored per version, e.g. <app data>/cache/ligolo/v0.8.2/.
+func LigoloCacheDir() (string, error) {
+	base, err := DefaultAppDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "cache", "ligolo"), nil
+}
+
+func ligoloCachePath(version, name string) (string, error) {
+	dir, err := LigoloCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, version, name), nil
+}
+
+// ligoloAsset describes a release file identified by its name.
+type ligoloAsset struct {
+	Component string
+	Version   string
+	Platform  LigoloPlatform
+	Checksums bool
+}
+
+// parseLigoloAssetName recognises release archive names such as
+// ligolo-ng_agent_0.8.2_windows_amd64.zip and ligolo-ng_0.8.2_checksums.txt.
+func parseLigoloAssetName(name string) (ligoloAsset, error) {
+	name = filepath.Base(name)
+	if !strings.HasPrefix(name, "ligolo-ng_") {
+		return ligoloAsset{}, fmt.Errorf("%s is not a ligolo-ng release file", name)
+	}
+	rest := strings.TrimPrefix(name, "ligolo-ng_")
+
+	if strings.HasSuffix(rest, "_checksums.txt") {
+		version := "v" + strings.TrimSuffix(rest, "_checksums.txt")
+		if err := ValidateLigoloVersion(version); err != nil {
+			return ligoloAsset{}, err
+		}
+		return ligoloAsset{Version: version, Checksums: true}, nil
+	}
+
+	var ext string
+	switch {
+	case strings.HasSuffix(rest, ".tar.gz"):
+		ext = ".tar.gz"
+	case strings.HasSuffix(rest, ".zip"):
+		ext = ".zip"
+	default:
+		return ligoloAsset{}, fmt.Errorf("%s: unsupported archive type", name)
+	}
+
+	parts := strings.Split(strings.TrimSuffix(rest, ext), "_")
+	if len(parts) != 4 || (parts[0] != "proxy" && parts[0] != "agent") {
+		return ligoloAsset{}, fmt.Errorf("%s: unrecognised release file name", name)
+	}
+	p, err := ParseLigoloPlatform(parts[2] + "/" + parts[3])
+	if err != nil {
+		return ligoloAsset{}, err
+	}
+	if p.ArchiveExt() != ext {
+		return ligoloAsset{}, fmt.Errorf("%s: unexpected archive type for %s", name, p)
+	}
+	version := "v" + parts[1]
+	if err := ValidateLigoloVersion(version); err != nil {
+		return ligoloAsset{}, err
+	}
+	return ligoloAsset{Component: parts[0], Version: version, Platform: p}, nil
+}
+
+// ImportLigoloFile copies a release archive or checksums.txt into the local
+// release cache and returns its cached path. The file name must follow the
+// upstream release naming so the component and platform can be identified.
+func ImportLigoloFile(name string, r io.Reader) (string, error) {
+	asset, err := parseLigoloAssetName(name)
+	if err != nil {
+		return "", err
+	}
+
+	cachePath, err := ligoloCachePath(asset.Version, filepath.Base(name))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), ".import-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		return "", err
+	}
+	return cachePath, nil
+}
+
+// ErrArchiveUnverified is returned by InstallLigoloArchive when there is
+// no cached checksums.txt to check the archive against, and unverified
+// archives weren't allowed.
+var ErrArchiveUnverified = errors.New("archive can't be verified")
+
+// cachedChecksums returns the checksums.txt entries cached for a version, if any.
+func cachedChecksums(version string) map[string]string {
+	bare := strings.TrimPrefix(version, "v")
+	path, err := ligoloCachePath(version, fmt.Sprintf("ligolo-ng_%s_checksums.txt", bare))
+	if err != nil {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	sums, _ := parseChecksums(f)
+	return sums
+}
+
+// InstallLigoloArchive installs a proxy or agent from an already-downloaded
+// release archive into its versioned directory and updates config the same
+// way RunSkiddieInstall does; a proxy archive switches the active version.
+// The archive is verified against a cached checksums.txt. Without one it is
+// refused with ErrArchiveUnverified, unless allowUnverified is set; its hash
+// is then recorded but flagged as unverified.
+func InstallLigoloArchive(path string, allowUnverified bool) (SkiddieResult, error) {
+	if err := SkiddieSupported(); err != nil {
+		return SkiddieResult{}, err
+	}
+
+	asset, err := parseLigoloAssetName(path)
+	if err != nil {
+		return SkiddieResult{}, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	if asset.Checksums {
+		return SkiddieResult{}, fmt.Errorf("%w: checksums file is not an installable archive", ErrInvalidArchive)
+	}
+
+	status, err := CheckLigoloInstalled()
+	if err != nil {
+		return SkiddieResult{}, err
+	}
+
+	result := SkiddieResult{
+		InstalledBefore: status.Installed,
+		ProxyPath:       status.ProxyPath,
+		AgentName:       status.AgentName,
+		Version:         status.Version,
+		Agents:          []string{},
+		Checksums:       map[string]string{},
+	}
+
+	name := filepath.Base(path)
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return result, err
+	}
+
+	verified := false
+	if sums := cachedChecksums(asset.Version); sums != nil {
+		want, err := expectedSHA256(sums, name)
+		if err != nil {
+			return result, err
+		}
+		if !strings.EqualFold(sum, want) {
+			return result, fmt.Errorf("%w for %s: got %s, want %s", ErrChecksumMismatch, name, sum, want)
+		}
+		verified = true
+	}
+	if !verified && !allowUnverified {
+		return result, fmt.Errorf("%w: no checksums.txt cached for %s; upload the release checksums.txt with %s", ErrArchiveUnverified, asset.Version, name)
+	}
+
+	host, err := ligoloHostPlatform()
+	if err != nil {
+		return result, err
+	}
+
+	versionDir, err := LigoloVersionDir(asset.Version)
+	if err != nil {
+		return result, err
+	}
+	member := asset.Platform.BinaryName(asset.Component)
+
+	switch asset.Component {
+	case "proxy":
+		if asset.Platform != host {
+			return result, fmt.Errorf("%w: proxy archive is for %s but this host is %s", ErrInvalidArchive, asset.Platform, host)
+		}
+		if err := extractArchive(path, member, versionDir, "proxy"); err != nil {
+			return result, fmt.Errorf("failed to install proxy: %w", err)
+		}
+		if err := SwitchLigoloVersion(asset.Version); err != nil {
+			return result, err
+		}
+		result.ProxyPath = filepath.Join(versionDir, "proxy")
+		result.Version = asset.Version
+	case "agent":
+		if err := extractArchive(path, member, versionDir, asset.Platform.AgentFilename()); err != nil {
+			return result, fmt.Errorf("failed to install agent %s: %w", asset.Platform, err)
+		}
+		if asset.Platform == host {
+			if err := extractArchive(path, member, versionDir, "agent"); err != nil {
+				return result, fmt.Errorf("failed to install agent: %w", err)
+			}
+		}
+
+		cfg, err := LoadConfig()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return result, err
+		}
+		if asset.Platform == host && asset.Version == cfg.LigoloVersion {
+			cfg.AgentBinary = "agent"
+			result.AgentName = "agent"
+		}
+		cfg.AgentPlatforms = append(cfg.AgentPlatforms, asset.Platform.String())
+		if err := SaveConfig(cfg); err != nil {
+			return result, err
+		}
+		result.Agents = append(result.Agents, asset.Platform.String())
+	}
+
+	result.Checksums[name] = sum
+	result.Message = fmt.Sprintf("Installed %s %s %s from local archive.", asset.Component, asset.Platform, asset.Version)
+	if !verified {
+		result.Message += " No checksums.txt cached for this release; hash not verified."
+	}
+	return result, nil
+}
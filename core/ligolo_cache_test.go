@@ -0,0 +1,117 @@
+package core
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeLigoloArchive returns a release archive for p holding component with
+// content, laid out like upstream: README files next to the binary.
+func fakeLigoloArchive(t *testing.T, component string, p LigoloPlatform, content string) []byte {
+	t.Helper()
+	files := []struct{ name, body string }{
+		{"LICENSE", "GPL-3.0"},
+		{"README.md", "ligolo-ng"},
+		{p.BinaryName(component), content},
+	}
+	var buf bytes.Buffer
+	if p.ArchiveExt() == ".zip" {
+		zw := zip.NewWriter(&buf)
+		for _, f := range files {
+			w, err := zw.Create(f.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(f.body))
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o755, Size: int64(len(f.body))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(f.body))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// checksumLine formats a checksums.txt entry.
+func checksumLine(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), name)
+}
+
+func TestInstallLigoloArchiveRequiresChecksums(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skiddie Mode is Linux only")
+	}
+	t.Setenv("HOME", t.TempDir())
+	win := LigoloPlatform{"windows", "amd64"}
+	name := LigoloAssetName("v0.0.1", "agent", win)
+	archive := fakeLigoloArchive(t, "agent", win, "MZ agent")
+
+	path, err := ImportLigoloFile(name, bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InstallLigoloArchive(path, false); !errors.Is(err, ErrArchiveUnverified) {
+		t.Fatalf("install without checksums = %v, want ErrArchiveUnverified", err)
+	}
+	installDir, _ := LigoloInstallDir()
+	agentPath := filepath.Join(installDir, "v0.0.1", win.AgentFilename())
+	if _, err := os.Stat(agentPath); !os.IsNotExist(err) {
+		t.Fatalf("unverified agent was installed: %v", err)
+	}
+
+	result, err := InstallLigoloArchive(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(agentPath); string(data) != "MZ agent" {
+		t.Errorf("installed agent = %q", data)
+	}
+	if !strings.Contains(result.Message, "not verified") {
+		t.Errorf("message %q does not flag the archive as unverified", result.Message)
+	}
+
+	// A cached checksums.txt verifies the archive without the opt-in...
+	sums := checksumLine(name, archive)
+	if _, err := ImportLigoloFile("ligolo-ng_0.0.1_checksums.txt", strings.NewReader(sums)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InstallLigoloArchive(path, false); err != nil {
+		t.Fatalf("install with checksums: %v", err)
+	}
+	// ...and a mismatch is refused even with it.
+	if err := os.WriteFile(path, append(archive, 0), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InstallLigoloArchive(path, true); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("install of a mismatched archive = %v, want ErrChecksumMismatch", err)
+	}
+	if _, err := InstallLigoloArchive(filepath.Join(filepath.Dir(path), "ligolo-ng_0.0.1_checksums.txt"), true); !errors.Is(err, ErrInvalidArchive) {
+		t.Errorf("install of checksums.txt = %v, want ErrInvalidArchive", err)
+	}
+}
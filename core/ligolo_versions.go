@@ -0,0 +1,203 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ligoloReleasesAPIURL lists upstream releases in GitHub API format. A
+// configured mirror must serve the same JSON at <mirror>/releases.json.
+var ligoloReleasesAPIURL = "https://api.github.com/repos/nicocha30/ligolo-ng/releases"
+
+var ligoloVersionRe = regexp.MustCompile(`^v\d+\.\d+\.\d+(-[0-9A-Za-z.]+)?$`)
+
+// ValidateLigoloVersion checks that version is a release tag such as v0.8.2.
+// Versions are used as directory names, so anything else is rejected.
+func ValidateLigoloVersion(version string) error {
+	if !ligoloVersionRe.MatchString(version) {
+		return fmt.Errorf("invalid ligolo-ng version %q", version)
+	}
+	return nil
+}
+
+// LigoloReleaseInfo describes a release available upstream or locally.
+type LigoloReleaseInfo struct {
+	Version    string    `json:"version"`
+	Published  time.Time `json:"published"`
+	Prerelease bool      `json:"prerelease"`
+	Cached     bool      `json:"cached"`
+	Installed  bool      `json:"installed"`
+	Active     bool      `json:"active"`
+}
+
+// ListLigoloVersions merges the upstream (or mirror) release list with the
+// versions found in the local cache and install dir. When the remote list
+// cannot be fetched, the local versions are still returned with the error.
+func ListLigoloVersions() ([]LigoloReleaseInfo, error) {
+	cfg, err := LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	cfg = SanitizeConfig(cfg)
+
+	byVersion := map[string]*LigoloReleaseInfo{}
+	get := func(v string) *LigoloReleaseInfo {
+		if r, ok := byVersion[v]; ok {
+			return r
+		}
+		r := &LigoloReleaseInfo{Version: v}
+		byVersion[v] = r
+		return r
+	}
+
+	remote, fetchErr := fetchLigoloReleases(cfg)
+	for _, r := range remote {
+		info := get(r.Version)
+		info.Published = r.Published
+		info.Prerelease = r.Prerelease
+	}
+
+	if cacheDir, err := LigoloCacheDir(); err == nil {
+		for _, v := range versionSubdirs(cacheDir) {
+			get(v).Cached = true
+		}
+	}
+	if installDir, err := LigoloInstallDir(); err == nil {
+		for _, v := range versionSubdirs(installDir) {
+			if _, err := os.Stat(filepath.Join(installDir, v, "proxy")); err == nil {
+				get(v).Installed = true
+			}
+		}
+	}
+	if cfg.LigoloVersion != "" {
+		get(cfg.LigoloVersion).Active = true
+	}
+
+	list := make([]LigoloReleaseInfo, 0, len(byVersion))
+	for _, r := range byVersion {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Published.Equal(list[j].Published) {
+			return list[i].Published.After(list[j].Published)
+		}
+		return list[i].Version > list[j].Version
+	})
+	return list, fetchErr
+}
+
+func fetchLigoloReleases(cfg Config) ([]LigoloReleaseInfo, error) {
+	url := ligoloReleasesAPIURL
+	if cfg.LigoloMirror != "" {
+		url = ligoloBaseURL(cfg) + "/releases.json"
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("release list fetch failed: %s", resp.Status)
+	}
+
+	var raw []struct {
+		TagName     string    `json:"tag_name"`
+		Prerelease  bool      `json:"prerelease"`
+		PublishedAt time.Time `json:"published_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	releases := []LigoloReleaseInfo{}
+	for _, r := range raw {
+		if ValidateLigoloVersion(r.TagName) != nil {
+			continue
+		}
+		releases = append(releases, LigoloReleaseInfo{Version: r.TagName, Published: r.PublishedAt, Prerelease: r.Prerelease})
+	}
+	return releases, nil
+}
+
+func versionSubdirs(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	versions := []string{}
+	for _, e := range entries {
+		if e.IsDir() && ValidateLigoloVersion(e.Name()) == nil {
+			versions = append(versions, e.Name())
+		}
+	}
+	return versions
+}
+
+// ErrSmokeTest is returned when a newly selected proxy doesn't run on this
+// host; the previous version is kept.
+var ErrSmokeTest = errors.New("failed smoke test")
+
+// SwitchLigoloVersion points Config.ProxyBinary at an installed version. The
+// new proxy must pass a smoke test; otherwise the previous config is restored.
+func SwitchLigoloVersion(version string) error {
+	dir, err := LigoloVersionDir(version)
+	if err != nil {
+		return err
+	}
+	proxyPath := filepath.Join(dir, "proxy")
+	if _, err := os.Stat(proxyPath); err != nil {
+		return fmt.Errorf("ligolo-ng %s is not installed", version)
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	prev := cfg
+
+	cfg.ProxyBinary = proxyPath
+	cfg.LigoloVersion = version
+	if _, err := os.Stat(filepath.Join(dir, "agent")); err == nil {
+		cfg.AgentBinary = "agent"
+	}
+	if err := SaveConfig(cfg); err != nil {
+		return err
+	}
+
+	if err := smokeTestProxy(proxyPath); err != nil {
+		if restoreErr := SaveConfig(prev); restoreErr != nil {
+			return fmt.Errorf("proxy %s %w (%v) and rollback failed: %w", version, ErrSmokeTest, err, restoreErr)
+		}
+		return fmt.Errorf("proxy %s %w, rolled back: %w", version, ErrSmokeTest, err)
+	}
+	return nil
+}
+
+// smokeTestProxy checks that the proxy binary runs on this host.
+func smokeTestProxy(path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, "-version").CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
@@ -2,33 +2,71 @@ package core
 
 import (
 	"fmt"
+	"io"
 	"os/exec"
-	"strings"
+	"time"
 )
 
+// agentTLSFlag pins the proxy certificate's fingerprint, falling back to
+// -ignore-cert while it is unknown (a selfcert proxy that hasn't started).
+func agentTLSFlag(cfg Config) string {
+	fp, err := ProxyCertFingerprint(cfg)
+	if err != nil || fp == "" {
+		return "-ignore-cert"
+	}
+	return "-accept-fingerprint " + fp
+}
+
 // AgentCmdLinux returns the command to run the agent on Linux.
 func AgentCmdLinux(cfg Config) string {
-	cfg = SanitizeConfig(cfg)
-	return fmt.Sprintf("./%s -connect %s:%d -ignore-cert", cfg.AgentBinary, cfg.PublicIP, cfg.ProxyPort)
+	return agentCommand(cfg, AgentCommandOptions{OS: "linux"})
 }
 
 // AgentCmdWindows returns a PowerShell one-liner to run the agent on Windows.
 func AgentCmdWindows(cfg Config) string {
-	cfg = SanitizeConfig(cfg)
-
-	binary := cfg.AgentBinary
-	if !strings.HasSuffix(strings.ToLower(binary), ".exe") {
-		binary += ".exe"
-	}
-
-	return fmt.Sprintf(`powershell -Command "Start-Process -FilePath .\\%s -ArgumentList '-connect %s:%d -ignore-cert'"`, binary, cfg.PublicIP, cfg.ProxyPort)
+	return agentCommand(cfg, AgentCommandOptions{OS: "windows"})
 }
 
-// StartProxy launches the ligolo proxy with the provided configuration.
-func StartProxy(cfg Config) (*exec.Cmd, error) {
+// StartProxy launches the ligolo proxy with the provided configuration and
+// returns it with the fingerprint of the certificate it serves ("" for
+// selfcert, which the proxy reports at startup). Local CA certificates are
+// issued or renewed here.
+// The proxy's stdout and stderr are copied to the given writers, which may be nil.
+// It runs in its own process group so it can be stopped along with its children.
+func StartProxy(cfg Config, stdout, stderr io.Writer) (*exec.Cmd, string, error) {
 	cfg = SanitizeConfig(cfg)
 	addr := fmt.Sprintf("%s:%d", cfg.ProxyBind, cfg.ProxyPort)
 
-	cmd := exec.Command(cfg.ProxyBinary, "-laddr", addr, "-selfcert")
-	return cmd, cmd.Start()
+	args := []string{"-laddr", addr}
+	certFile, keyFile, err := proxyCertFiles(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	fingerprint := ""
+	if certFile != "" {
+		if fingerprint, err = certFileFingerprint(certFile); err != nil {
+			return nil, "", err
+		}
+		args = append(args, "-certfile", certFile, "-keyfile", keyFile)
+	} else {
+		args = append(args, "-selfcert")
+	}
+	if cfg.ProxyAPIEnabled {
+		if cfg, err = EnsureProxyAPIPassword(cfg); err != nil {
+			return nil, "", fmt.Errorf("failed to save proxy API password: %w", err)
+		}
+		path, err := WriteLigoloProxyConfig(cfg)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to write proxy config: %w", err)
+		}
+		args = append(args, "-config", path)
+	}
+
+	cmd := exec.Command(cfg.ProxyBinary, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = childProcAttr()
+	// Don't let a child holding the output pipes keep Wait from returning.
+	cmd.WaitDelay = 2 * time.Second
+	return cmd, fingerprint, cmd.Start()
 }
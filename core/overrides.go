@@ -0,0 +1,351 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sources reported by ConfigSources, in increasing precedence.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// EnvPrefix prefixes the environment variables overriding config fields,
+// e.g. POTG_PROXY_PORT for proxy_port.
+const EnvPrefix = "POTG_"
+
+// ConfigKey describes a Config field that can be overridden by flag or
+// environment variable.
+type ConfigKey struct {
+	Key  string `json:"key"`  // JSON key, e.g. proxy_port
+	Env  string `json:"env"`  // e.g. POTG_PROXY_PORT
+	Flag string `json:"flag"` // e.g. proxy-port
+	Bool bool   `json:"bool"`
+	kind reflect.Type
+}
+
+var (
+	overrideMu      sync.RWMutex
+	flagOverrides   = map[string]string{}
+	configPathFlag  string
+	appDataDirFlag  string
+	configKeysOnce  sync.Once
+	configKeysCache []ConfigKey
+)
+
+// ConfigKeys lists the overridable Config fields in declaration order.
+func ConfigKeys() []ConfigKey {
+	configKeysOnce.Do(func() {
+		t := reflect.TypeOf(Config{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			key, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if key == "" || key == "-" || key == "schema_version" {
+				continue
+			}
+			configKeysCache = append(configKeysCache, ConfigKey{
+				Key:  key,
+				Env:  EnvPrefix + strings.ToUpper(key),
+				Flag: strings.ReplaceAll(key, "_", "-"),
+				Bool: f.Type.Kind() == reflect.Bool,
+				kind: f.Type,
+			})
+		}
+	})
+	return configKeysCache
+}
+
+func lookupConfigKey(key string) (ConfigKey, bool) {
+	for _, k := range ConfigKeys() {
+		if k.Key == key {
+			return k, true
+		}
+	}
+	return ConfigKey{}, false
+}
+
+// SetFlagOverrides records config values given as command line flags, keyed
+// by JSON key. They take precedence over environment variables.
+func SetFlagOverrides(values map[string]string) error {
+	for key, value := range values {
+		k, ok := lookupConfigKey(key)
+		if !ok {
+			return fmt.Errorf("unknown config key %q", key)
+		}
+		if _, err := overrideJSON(k, value); err != nil {
+			return fmt.Errorf("--%s: %w", k.Flag, err)
+		}
+	}
+	overrideMu.Lock()
+	defer overrideMu.Unlock()
+	flagOverrides = map[string]string{}
+	for key, value := range values {
+		flagOverrides[key] = value
+	}
+	return nil
+}
+
+// SetConfigPathOverride replaces the global config file location (flag
+// --config). The workspace index and agent templates move with it.
+func SetConfigPathOverride(path string) {
+	overrideMu.Lock()
+	defer overrideMu.Unlock()
+	configPathFlag = path
+}
+
+// SetAppDataDirOverride replaces the app data dir (flag --data-dir).
+func SetAppDataDirOverride(dir string) {
+	overrideMu.Lock()
+	defer overrideMu.Unlock()
+	appDataDirFlag = dir
+}
+
+// configPathOverride returns the config file location from --config or
+// POTG_CONFIG, if set.
+func configPathOverride() string {
+	overrideMu.RLock()
+	defer overrideMu.RUnlock()
+	if configPathFlag != "" {
+		return configPathFlag
+	}
+	return strings.TrimSpace(os.Getenv(EnvPrefix + "CONFIG"))
+}
+
+// appDataDirOverride returns the app data dir from --data-dir or
+// POTG_DATA_DIR, if set.
+func appDataDirOverride() string {
+	overrideMu.RLock()
+	defer overrideMu.RUnlock()
+	if appDataDirFlag != "" {
+		return appDataDirFlag
+	}
+	return strings.TrimSpace(os.Getenv(EnvPrefix + "DATA_DIR"))
+}
+
+// configOverride returns the flag or environment value for key and its
+// source, if either is set.
+func configOverride(k ConfigKey) (value, source string, ok bool) {
+	overrideMu.RLock()
+	value, ok = flagOverrides[k.Key]
+	overrideMu.RUnlock()
+	if ok {
+		return value, SourceFlag, true
+	}
+	if value, ok = os.LookupEnv(k.Env); ok {
+		return value, SourceEnv, true
+	}
+	return "", "", false
+}
+
+// CheckConfigOverrides reports environment variables that don't parse as
+// their field's type. Flags are checked by SetFlagOverrides.
+func CheckConfigOverrides() error {
+	for _, k := range ConfigKeys() {
+		value, source, ok := configOverride(k)
+		if !ok || source != SourceEnv {
+			continue
+		}
+		if _, err := overrideJSON(k, value); err != nil {
+			return fmt.Errorf("%s: %w", k.Env, err)
+		}
+	}
+	return nil
+}
+
+// overrideJSON converts a flag or environment value to JSON for the field.
+// Lists are comma-separated; proxy_instances takes a JSON array.
+func overrideJSON(k ConfigKey, value string) (json.RawMessage, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case k.kind.Kind() == reflect.String:
+		return json.Marshal(value)
+	case k.kind.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", value)
+		}
+		return json.Marshal(n)
+	case k.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not true or false", value)
+		}
+		return json.Marshal(b)
+	case k.kind.Kind() == reflect.Slice && k.kind.Elem().Kind() == reflect.String:
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return json.Marshal(items)
+	default:
+		v := reflect.New(k.kind).Interface()
+		if err := json.Unmarshal([]byte(value), v); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return json.RawMessage(value), nil
+	}
+}
+
+// applyConfigOverrides sets every field given by flag or environment
+// variable. Values that don't parse are skipped.
+func applyConfigOverrides(cfg Config) Config {
+	raw := map[string]json.RawMessage{}
+	for _, k := range ConfigKeys() {
+		value, _, ok := configOverride(k)
+		if !ok {
+			continue
+		}
+		if data, err := overrideJSON(k, value); err == nil {
+			raw[k.Key] = data
+		}
+	}
+	return mergeConfigJSON(cfg, raw)
+}
+
+// SetConfigField sets the field with the given JSON key from its string
+// form, parsed as for flags and environment variables.
+func SetConfigField(cfg Config, key, value string) (Config, error) {
+	k, ok := lookupConfigKey(key)
+	if !ok {
+		return cfg, fmt.Errorf("unknown config key %q", key)
+	}
+	data, err := overrideJSON(k, value)
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %w", key, err)
+	}
+	return mergeConfigJSON(cfg, map[string]json.RawMessage{key: data}), nil
+}
+
+// mergeConfigJSON returns a copy of cfg with the given JSON fields replaced.
+// The copy is decoded from scratch so it shares no slices with cfg.
+func mergeConfigJSON(cfg Config, fields map[string]json.RawMessage) Config {
+	if len(fields) == 0 {
+		return cfg
+	}
+	raw := map[string]json.RawMessage{}
+	data, err := json.Marshal(cfg)
+	if err != nil || json.Unmarshal(data, &raw) != nil {
+		return cfg
+	}
+	for key, value := range fields {
+		raw[key] = value
+	}
+	if data, err = json.Marshal(raw); err != nil {
+		return cfg
+	}
+	var out Config
+	if err := json.Unmarshal(data, &out); err != nil {
+		return cfg
+	}
+	out.ProxyFingerprint = cfg.ProxyFingerprint
+	return out
+}
+
+// OverriddenConfigKeys returns the JSON keys set by flag or environment
+// variable, sorted.
+func OverriddenConfigKeys() []string {
+	keys := []string{}
+	for _, k := range ConfigKeys() {
+		if _, _, ok := configOverride(k); ok {
+			keys = append(keys, k.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// stripConfigOverrides replaces overridden fields of cfg with stored (or
+// default) values, so flags and environment variables never end up in the
+// file written to path.
+func stripConfigOverrides(path string, cfg Config) Config {
+	keys := OverriddenConfigKeys()
+	if len(keys) == 0 {
+		return cfg
+	}
+	base := map[string]json.RawMessage{}
+	if data, err := json.Marshal(DefaultConfig()); err == nil {
+		_ = json.Unmarshal(data, &base)
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		if stored, _, err := decodeConfig(data); err == nil {
+			if data, err := json.Marshal(stored); err == nil {
+				_ = json.Unmarshal(data, &base)
+			}
+		}
+	}
+
+	raw := map[string]json.RawMessage{}
+	for _, key := range keys {
+		raw[key] = base[key]
+	}
+	return mergeConfigJSON(cfg, raw)
+}
+
+// ConfigSources reports where the effective value of every overridable
+// field of the active config comes from: SourceFlag, SourceEnv, SourceFile
+// or SourceDefault. SaveConfig writes every field, so a stored value equal
+// to the default is reported as SourceDefault.
+func ConfigSources() map[string]string {
+	present, stored, defaults := map[string]json.RawMessage{}, map[string]json.RawMessage{}, map[string]json.RawMessage{}
+	if path, err := ConfigPath(); err == nil {
+		if data, err := os.ReadFile(path); err == nil {
+			_ = json.Unmarshal(data, &present)
+			if cfg, _, err := decodeConfig(data); err == nil {
+				if data, err := json.Marshal(SanitizeConfig(cfg)); err == nil {
+					_ = json.Unmarshal(data, &stored)
+				}
+			}
+		}
+	}
+	if data, err := json.Marshal(SanitizeConfig(DefaultConfig())); err == nil {
+		_ = json.Unmarshal(data, &defaults)
+	}
+	sources := map[string]string{}
+	for _, k := range ConfigKeys() {
+		if _, source, ok := configOverride(k); ok {
+			sources[k.Key] = source
+		} else if _, ok := present[k.Key]; ok && string(stored[k.Key]) != string(defaults[k.Key]) {
+			sources[k.Key] = SourceFile
+		} else {
+			sources[k.Key] = SourceDefault
+		}
+	}
+	return sources
+}
+
+// OverrideConflicts reports overridden fields that an update sets to a value
+// other than the override. Such changes would be silently lost, since
+// overrides win and are never saved.
+func OverrideConflicts(cfg Config) FieldErrors {
+	errs := FieldErrors{}
+	got, want := map[string]json.RawMessage{}, map[string]json.RawMessage{}
+	if data, err := json.Marshal(SanitizeConfig(cfg)); err == nil {
+		_ = json.Unmarshal(data, &got)
+	}
+	if data, err := json.Marshal(SanitizeConfig(applyConfigOverrides(cfg))); err == nil {
+		_ = json.Unmarshal(data, &want)
+	}
+	for _, k := range ConfigKeys() {
+		_, source, ok := configOverride(k)
+		if !ok || string(got[k.Key]) == string(want[k.Key]) {
+			continue
+		}
+		if source == SourceFlag {
+			errs.add(k.Key, "is set by the --%s flag", k.Flag)
+		} else {
+			errs.add(k.Key, "is set by the %s environment variable", k.Env)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func setFlagOverrides(t *testing.T, values map[string]string) {
+	t.Helper()
+	if err := SetFlagOverrides(values); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetFlagOverrides(nil) })
+}
+
+func TestConfigOverridePrecedence(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := DefaultConfig()
+	cfg.ProxyPort = 12000
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(step string, port int, source string) {
+		t.Helper()
+		cfg, err := LoadConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources := ConfigSources()
+		if cfg.ProxyPort != port || sources["proxy_port"] != source {
+			t.Errorf("%s: proxy_port = %d from %s, want %d from %s", step, cfg.ProxyPort, sources["proxy_port"], port, source)
+		}
+		if sources["file_port"] != SourceDefault {
+			t.Errorf("%s: stored default file_port reported from %s", step, sources["file_port"])
+		}
+	}
+	check("file", 12000, SourceFile)
+	t.Setenv("POTG_PROXY_PORT", "13000")
+	check("env", 13000, SourceEnv)
+	setFlagOverrides(t, map[string]string{"proxy_port": "14000"})
+	check("flag", 14000, SourceFlag)
+
+	for _, bad := range []map[string]string{{"no_such_key": "1"}, {"proxy_port": "many"}, {"file_tls": "maybe"}} {
+		if err := SetFlagOverrides(bad); err == nil {
+			t.Errorf("SetFlagOverrides(%v) succeeded", bad)
+		}
+	}
+	if cfg, _ := LoadConfig(); cfg.ProxyPort != 14000 {
+		t.Errorf("a rejected SetFlagOverrides dropped the previous flags")
+	}
+
+	t.Setenv("POTG_FILE_PORT", "eighty")
+	if err := CheckConfigOverrides(); err == nil || !strings.Contains(err.Error(), "POTG_FILE_PORT") {
+		t.Errorf("CheckConfigOverrides = %v", err)
+	}
+}
+
+func TestConfigOverrideListValues(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("POTG_AGENT_PLATFORMS", " linux/amd64, ,windows/amd64 ")
+	t.Setenv("POTG_FILE_TLS", "true")
+	t.Setenv("POTG_PROXY_INSTANCES", `[{"name":"second","port":11602}]`)
+	cfg, _ := LoadConfig()
+	if strings.Join(cfg.AgentPlatforms, " ") != "linux/amd64 windows/amd64" || !cfg.FileTLS {
+		t.Errorf("agent_platforms %q, file_tls %v", cfg.AgentPlatforms, cfg.FileTLS)
+	}
+	if len(cfg.ProxyInstances) != 1 || cfg.ProxyInstances[0].Port != 11602 {
+		t.Errorf("proxy_instances = %+v", cfg.ProxyInstances)
+	}
+}
+
+func TestSaveConfigStripsOverrides(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := DefaultConfig()
+	cfg.ProxyPort = 12000
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("POTG_PROXY_PORT", "13000")
+	setFlagOverrides(t, map[string]string{"public_ip": "10.10.14.2"})
+
+	cfg, _ = LoadConfig()
+	cfg.FilePort = 8443
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := ConfigPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored Config
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.ProxyPort != 12000 || stored.PublicIP != defaultPublicIP {
+		t.Errorf("saved overrides: proxy_port %d, public_ip %q", stored.ProxyPort, stored.PublicIP)
+	}
+	if stored.FilePort != 8443 {
+		t.Errorf("file_port %d, want the update saved", stored.FilePort)
+	}
+}
+
+func TestOverrideConflicts(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("POTG_PROXY_PORT", "13000")
+	setFlagOverrides(t, map[string]string{"public_ip": "10.10.14.2"})
+
+	cfg, _ := LoadConfig()
+	if errs := OverrideConflicts(cfg); len(errs) != 0 {
+		t.Errorf("unchanged overrides reported: %v", errs)
+	}
+	cfg.FilePort = 8443
+	if errs := OverrideConflicts(cfg); len(errs) != 0 {
+		t.Errorf("an update to a field without override reported: %v", errs)
+	}
+
+	cfg.ProxyPort = 15000
+	cfg.PublicIP = " 10.10.14.3"
+	got := fieldMessages(OverrideConflicts(cfg))
+	if got["proxy_port"] != "is set by the POTG_PROXY_PORT environment variable" {
+		t.Errorf("proxy_port conflict = %q", got["proxy_port"])
+	}
+	if got["public_ip"] != "is set by the --public-ip flag" {
+		t.Errorf("public_ip conflict = %q", got["public_ip"])
+	}
+	if len(got) != 2 {
+		t.Errorf("conflicts = %v", got)
+	}
+}
@@ -9,8 +9,12 @@ import (
 // DefaultAppDataDir returns a per-user app data directory for PivotOnTheGO.
 // Example: ~/.local/share/PivotOnTheGO on Linux. If the new path does not exist
 // but an older SwissArmyToolkit directory exists, it falls back to the legacy
-// path to avoid breaking existing data.
+// path to avoid breaking existing data. --data-dir or POTG_DATA_DIR replaces
+// it entirely.
 func DefaultAppDataDir() (string, error) {
+	if dir := appDataDirOverride(); dir != "" {
+		return dir, nil
+	}
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
@@ -33,13 +37,9 @@ func LegacyAppDataDirPath() string {
 	return filepath.Join(home, ".local", "share", "SwissArmyToolkit")
 }
 
-// DefaultLootDir returns the default loot directory path.
+// DefaultLootDir returns the loot directory of the active workspace.
 func DefaultLootDir() (string, error) {
-	base, err := DefaultAppDataDir()
-	if err != nil {
-		return "", err
-	}
-	return filepath.Join(base, "loot"), nil
+	return WorkspaceLootDir(ActiveWorkspace())
 }
 
 // InitLootDir ensures the loot directory exists and has starter files.
@@ -48,28 +48,34 @@ func InitLootDir() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if err := initLootDirAt(lootDir); err != nil {
+		return "", err
+	}
+	return lootDir, nil
+}
 
+func initLootDirAt(lootDir string) error {
 	if err := os.MkdirAll(lootDir, 0o755); err != nil {
-		return "", err
+		return err
 	}
 
 	marker := filepath.Join(lootDir, ".initialized")
 	if _, err := os.Stat(marker); err == nil {
-		return lootDir, nil
+		return nil
 	}
 
 	if err := writeIfNotExists(filepath.Join(lootDir, "README_LOOT.txt"), defaultLootReadme()); err != nil {
-		return "", err
+		return err
 	}
 	if err := writeIfNotExists(filepath.Join(lootDir, "commands_linux.txt"), defaultLinuxCommands()); err != nil {
-		return "", err
+		return err
 	}
 	if err := writeIfNotExists(filepath.Join(lootDir, "commands_windows.txt"), defaultWindowsCommands()); err != nil {
-		return "", err
+		return err
 	}
 
 	_ = os.WriteFile(marker, []byte(time.Now().Format(time.RFC3339)), 0o644)
-	return lootDir, nil
+	return nil
 }
 
 func writeIfNotExists(path string, content string) error {
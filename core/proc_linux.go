@@ -0,0 +1,18 @@
+//go:build linux
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// processCmdline returns the argv of a running process.
+func processCmdline(pid int) ([]string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(data), "\x00"), "\x00"), nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package core
+
+import "errors"
+
+// processCmdline is only implemented on Linux.
+func processCmdline(pid int) ([]string, error) {
+	return nil, errors.ErrUnsupported
+}
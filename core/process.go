@@ -0,0 +1,38 @@
+package core
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// processStopGrace is how long a child gets to exit after SIGTERM before it
+// is killed.
+const processStopGrace = 5 * time.Second
+
+// newCommand builds a child command in its own process group. Cancelling ctx
+// sends SIGTERM to the whole group, then SIGKILL after processStopGrace.
+func newCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = childProcAttr()
+	cmd.Cancel = func() error {
+		return terminateProcessGroup(cmd.Process.Pid)
+	}
+	cmd.WaitDelay = processStopGrace
+	return cmd
+}
+
+// stopProcessGroup asks the process group led by pid to exit, waiting up to
+// grace for done to close before killing the group. Any stragglers left in
+// the group after the leader exits are killed as well.
+func stopProcessGroup(pid int, done <-chan struct{}, grace time.Duration) {
+	_ = terminateProcessGroup(pid)
+
+	select {
+	case <-done:
+	case <-time.After(grace):
+		_ = killProcessGroup(pid)
+		<-done
+	}
+	_ = killProcessGroup(pid)
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// LigoloProxyConfigPath returns the ligolo-ng.yaml passed to the proxy with
+// -config when the API is enabled.
+func LigoloProxyConfigPath() (string, error) {
+	dir, err := LigoloInstallDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ligolo-ng.yaml"), nil
+}
+
+// WriteLigoloProxyConfig writes the proxy config enabling the web API on
+// cfg.ProxyAPIAddr and returns its path. The only API account is
+// cfg.ProxyAPIUser with an argon2id hash of cfg.ProxyAPIPassword, so the proxy
+// accepts exactly the credentials in the config. The API signing secret, and
+// the hash while the password is unchanged, are kept across restarts.
+func WriteLigoloProxyConfig(cfg Config) (string, error) {
+	cfg = SanitizeConfig(cfg)
+	if cfg.ProxyAPIPassword == "" {
+		return "", errors.New("no proxy API password configured")
+	}
+
+	path, err := LigoloProxyConfigPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+
+	secret, users := "", map[string]string{}
+	if data, err := os.ReadFile(path); err == nil {
+		secret, users = existingWebSettings(data)
+	}
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		secret = hex.EncodeToString(b)
+	}
+	hash := users[cfg.ProxyAPIUser]
+	if !proxyAPIPasswordMatches(hash, cfg.ProxyAPIPassword) {
+		if hash, err = hashProxyAPIPassword(cfg.ProxyAPIPassword); err != nil {
+			return "", err
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by PivotOnTheGO on every proxy start from the proxy_api_* settings.\n")
+	buf.WriteString("web:\n")
+	buf.WriteString("  enabled: true\n")
+	buf.WriteString("  enableui: false\n")
+	fmt.Fprintf(&buf, "  listen: %s\n", cfg.ProxyAPIAddr)
+	fmt.Fprintf(&buf, "  secret: %s\n", secret)
+	buf.WriteString("  behindreverseproxy: false\n")
+	buf.WriteString("  debug: false\n")
+	buf.WriteString("  tls:\n")
+	buf.WriteString("    enabled: false\n")
+	buf.WriteString("  users:\n")
+	fmt.Fprintf(&buf, "    %q: %q\n", cfg.ProxyAPIUser, hash)
+
+	return path, writeFileAtomic(path, buf.Bytes(), 0o600)
+}
+
+// EnsureProxyAPIPassword generates and saves a proxy API password when the
+// API is enabled without one.
+func EnsureProxyAPIPassword(cfg Config) (Config, error) {
+	if !cfg.ProxyAPIEnabled || cfg.ProxyAPIPassword != "" {
+		return cfg, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return cfg, err
+	}
+	cfg.ProxyAPIPassword = hex.EncodeToString(b)
+	return cfg, SaveConfig(cfg)
+}
+
+// Argon2id parameters for proxy API password hashes, in the PHC string
+// format ligolo-ng reads from web.users.
+const (
+	proxyAPIHashTime    = 1
+	proxyAPIHashMemory  = 64 * 1024
+	proxyAPIHashThreads = 2
+	proxyAPIHashKeyLen  = 32
+)
+
+func hashProxyAPIPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, proxyAPIHashTime, proxyAPIHashMemory, proxyAPIHashThreads, proxyAPIHashKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, proxyAPIHashMemory, proxyAPIHashTime, proxyAPIHashThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// proxyAPIPasswordMatches reports whether hash is an argon2id hash of
+// password.
+func proxyAPIPasswordMatches(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return false
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || time == 0 || threads == 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
+
+// existingWebSettings pulls web.secret and the web.users hashes out of an
+// existing ligolo-ng.yaml.
+func existingWebSettings(data []byte) (string, map[string]string) {
+	secret, users := "", map[string]string{}
+	inWeb, inUsers := false, false
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := sc.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		if indent == 0 {
+			inWeb = trimmed == "web:"
+			inUsers = false
+			continue
+		}
+		if !inWeb {
+			continue
+		}
+		if indent == 2 {
+			inUsers = trimmed == "users:"
+			if v, ok := strings.CutPrefix(trimmed, "secret:"); ok {
+				secret = yamlScalar(v)
+			}
+			continue
+		}
+		if inUsers {
+			if name, hash, ok := strings.Cut(trimmed, ": "); ok {
+				users[yamlScalar(name)] = yamlScalar(hash)
+			}
+		}
+	}
+	return secret, users
+}
+
+// yamlScalar unquotes a plain or quoted YAML scalar.
+func yamlScalar(v string) string {
+	v = strings.TrimSpace(v)
+	if u, err := strconv.Unquote(v); err == nil && strings.HasPrefix(v, `"`) {
+		return u
+	}
+	return strings.Trim(v, `"'`)
+}
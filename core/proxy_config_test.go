@@ -0,0 +1,76 @@
+package core
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWriteLigoloProxyConfigUsers(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := DefaultConfig()
+	cfg.ProxyAPIEnabled = true
+
+	if _, err := WriteLigoloProxyConfig(cfg); err == nil {
+		t.Fatal("WriteLigoloProxyConfig without a password succeeded")
+	}
+
+	cfg.ProxyAPIPassword = "s3cret"
+	path, err := WriteLigoloProxyConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A hand-added account is dropped; only the configured one is kept.
+	data, _ := os.ReadFile(path)
+	if err := os.WriteFile(path, []byte(strings.Replace(string(data), "  users:\n", "  users:\n    admin: \"$argon2id$v=19$m=8,t=1,p=1$c2FsdA$a2V5\"\n", 1)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := os.ReadFile(path)
+	secret, users := existingWebSettings(first)
+	if secret == "" || len(users) != 2 {
+		t.Fatalf("existingWebSettings = %q, %v", secret, users)
+	}
+	if !proxyAPIPasswordMatches(users["ligolo"], "s3cret") || proxyAPIPasswordMatches(users["ligolo"], "password") {
+		t.Fatalf("hash %q does not match the configured password", users["ligolo"])
+	}
+
+	// An unchanged password keeps its hash and the signing secret.
+	if _, err := WriteLigoloProxyConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := os.ReadFile(path)
+	secret2, users2 := existingWebSettings(second)
+	if secret2 != secret || users2["ligolo"] != users["ligolo"] || len(users2) != 1 {
+		t.Fatalf("restart changed the config:\n%s\nto\n%s", first, second)
+	}
+
+	// A new user and password replace the account.
+	cfg.ProxyAPIUser = "operator"
+	cfg.ProxyAPIPassword = "changed"
+	if _, err := WriteLigoloProxyConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	third, _ := os.ReadFile(path)
+	_, users3 := existingWebSettings(third)
+	if len(users3) != 1 || !proxyAPIPasswordMatches(users3["operator"], "changed") {
+		t.Fatalf("users after a password change = %v", users3)
+	}
+}
+
+func TestEnsureProxyAPIPassword(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := DefaultConfig()
+	cfg.ProxyAPIEnabled = true
+	cfg, err := EnsureProxyAPIPassword(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.ProxyAPIPassword) != 32 {
+		t.Fatalf("generated password %q", cfg.ProxyAPIPassword)
+	}
+	saved, err := LoadConfig()
+	if err != nil || saved.ProxyAPIPassword != cfg.ProxyAPIPassword {
+		t.Fatalf("saved password %q, %v; want %q", saved.ProxyAPIPassword, err, cfg.ProxyAPIPassword)
+	}
+}
@@ -0,0 +1,264 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultProxyInstance names the proxy configured by the top-level
+// ProxyBind/ProxyPort/ProxyBinary settings.
+const DefaultProxyInstance = "default"
+
+var proxyInstanceNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ProxyInstance is an additional named proxy, e.g. a second listener on 443
+// for a locked-down segment. Empty Bind and Binary fall back to the
+// top-level settings; setting both CertFile and KeyFile uses that cert
+// instead of the top-level cert mode.
+type ProxyInstance struct {
+	Name     string `json:"name"`
+	Bind     string `json:"bind"`
+	Port     int    `json:"port"`
+	Binary   string `json:"binary"`
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// ValidateProxyInstanceName checks that name is usable as an instance name.
+// Names end up in log and state file names, so the character set is limited.
+func ValidateProxyInstanceName(name string) error {
+	if !proxyInstanceNameRe.MatchString(name) {
+		return fmt.Errorf("invalid proxy instance name %q (use a-z, 0-9, - and _)", name)
+	}
+	return nil
+}
+
+// sanitizeProxyInstances drops instances with invalid or duplicate names or
+// ports, including clashes with the default instance.
+func sanitizeProxyInstances(cfg Config) []ProxyInstance {
+	names := map[string]bool{DefaultProxyInstance: true}
+	ports := map[int]bool{cfg.ProxyPort: true}
+
+	out := []ProxyInstance{}
+	for _, inst := range cfg.ProxyInstances {
+		inst.Name = strings.TrimSpace(inst.Name)
+		inst.Bind = strings.TrimSpace(inst.Bind)
+		inst.Binary = strings.TrimSpace(inst.Binary)
+		inst.CertFile = strings.TrimSpace(inst.CertFile)
+		inst.KeyFile = strings.TrimSpace(inst.KeyFile)
+		if ValidateProxyInstanceName(inst.Name) != nil || names[inst.Name] {
+			continue
+		}
+		if inst.Port <= 0 || inst.Port > 65535 || ports[inst.Port] {
+			continue
+		}
+		names[inst.Name] = true
+		ports[inst.Port] = true
+		out = append(out, inst)
+	}
+	return out
+}
+
+// ProxyInstanceNames returns the default instance followed by the configured
+// instances in name order.
+func ProxyInstanceNames(cfg Config) []string {
+	names := []string{}
+	for _, inst := range cfg.ProxyInstances {
+		names = append(names, inst.Name)
+	}
+	sort.Strings(names)
+	return append([]string{DefaultProxyInstance}, names...)
+}
+
+// ProxyInstanceConfig returns cfg with the proxy settings of the named
+// instance applied, ready for StartProxy and the agent command builders.
+// The proxy API is only enabled on the default instance.
+func ProxyInstanceConfig(cfg Config, name string) (Config, error) {
+	cfg = SanitizeConfig(cfg)
+	if name == "" || name == DefaultProxyInstance {
+		return cfg, nil
+	}
+	for _, inst := range cfg.ProxyInstances {
+		if inst.Name != name {
+			continue
+		}
+		cfg.ProxyPort = inst.Port
+		if inst.Bind != "" {
+			cfg.ProxyBind = inst.Bind
+		}
+		if inst.Binary != "" {
+			cfg.ProxyBinary = inst.Binary
+		}
+		if inst.CertFile != "" && inst.KeyFile != "" {
+			cfg.ProxyCertMode = CertModeCustom
+			cfg.ProxyCertFile = inst.CertFile
+			cfg.ProxyKeyFile = inst.KeyFile
+		}
+		cfg.ProxyAPIEnabled = false
+		return cfg, nil
+	}
+	return cfg, fmt.Errorf("unknown proxy instance %q", name)
+}
+
+// proxyFileName maps an instance to the base name of its log and run state
+// files. The default instance keeps the original "proxy" names.
+func proxyFileName(name string) string {
+	if name == DefaultProxyInstance {
+		return "proxy"
+	}
+	return "proxy-" + name
+}
+
+// ProxyRegistry owns one supervisor and output log per proxy instance,
+// created on first use.
+type ProxyRegistry struct {
+	mu     sync.Mutex
+	logDir string
+	procs  map[string]*proxyEntry
+}
+
+type proxyEntry struct {
+	sup *ProxySupervisor
+	log *ProxyLog
+}
+
+// NewProxyRegistry returns a registry writing instance logs to logDir. An
+// empty logDir keeps output in memory only.
+func NewProxyRegistry(logDir string) *ProxyRegistry {
+	return &ProxyRegistry{logDir: logDir, procs: map[string]*proxyEntry{}}
+}
+
+func (r *ProxyRegistry) entry(name string) (*proxyEntry, error) {
+	if name == "" {
+		name = DefaultProxyInstance
+	}
+	if err := ValidateProxyInstanceName(name); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.procs[name]; ok {
+		return e, nil
+	}
+
+	file := proxyFileName(name)
+	log, err := NewProxyLog(r.logDir, file)
+	if err != nil {
+		log, _ = NewProxyLog("", file)
+	}
+	e := &proxyEntry{sup: NewProxySupervisor(file, log), log: log}
+	r.procs[name] = e
+	return e, nil
+}
+
+// Supervisor returns the supervisor of the named instance.
+func (r *ProxyRegistry) Supervisor(name string) (*ProxySupervisor, error) {
+	e, err := r.entry(name)
+	if err != nil {
+		return nil, err
+	}
+	return e.sup, nil
+}
+
+// Log returns the output log of the named instance.
+func (r *ProxyRegistry) Log(name string) (*ProxyLog, error) {
+	e, err := r.entry(name)
+	if err != nil {
+		return nil, err
+	}
+	return e.log, nil
+}
+
+// InstanceConfig is ProxyInstanceConfig plus the certificate fingerprint of
+// the instance's running proxy, for building agent commands.
+func (r *ProxyRegistry) InstanceConfig(cfg Config, name string) (Config, error) {
+	instCfg, err := ProxyInstanceConfig(cfg, name)
+	if err != nil {
+		return instCfg, err
+	}
+	if sup, err := r.Supervisor(name); err == nil {
+		instCfg.ProxyFingerprint = sup.Fingerprint()
+	}
+	return instCfg, nil
+}
+
+// AdoptAll re-attaches to any configured instance left running by a previous
+// UI process. It returns the names adopted and any per-instance errors.
+func (r *ProxyRegistry) AdoptAll(cfg Config) ([]string, []error) {
+	cfg = SanitizeConfig(cfg)
+	adopted := []string{}
+	var errs []error
+	for _, name := range ProxyInstanceNames(cfg) {
+		instCfg, err := ProxyInstanceConfig(cfg, name)
+		if err == nil {
+			var sup *ProxySupervisor
+			if sup, err = r.Supervisor(name); err == nil {
+				var ok bool
+				if ok, err = sup.Adopt(instCfg); ok {
+					adopted = append(adopted, name)
+				}
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return adopted, errs
+}
+
+// Running returns the names of instances whose proxy is starting or running.
+func (r *ProxyRegistry) Running() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := []string{}
+	for name, e := range r.procs {
+		if e.sup.Status().Running() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StopAll stops every running instance and returns the names stopped.
+func (r *ProxyRegistry) StopAll() []string {
+	r.mu.Lock()
+	entries := map[string]*proxyEntry{}
+	for name, e := range r.procs {
+		entries[name] = e
+	}
+	r.mu.Unlock()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		stopped = []string{}
+	)
+	for name, e := range entries {
+		wg.Add(1)
+		go func(name string, sup *ProxySupervisor) {
+			defer wg.Done()
+			if sup.Stop() {
+				mu.Lock()
+				stopped = append(stopped, name)
+				mu.Unlock()
+			}
+		}(name, e.sup)
+	}
+	wg.Wait()
+	sort.Strings(stopped)
+	return stopped
+}
+
+// Close closes every instance log.
+func (r *ProxyRegistry) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.procs {
+		_ = e.log.Close()
+	}
+}
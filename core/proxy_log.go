@@ -0,0 +1,238 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProxyLogLines   = 1000
+	proxyLogMaxBytes       = 5 << 20
+	proxyLogKeepFiles      = 3
+	proxyLogMaxPartialLine = 4096
+)
+
+var ansiEscapeRe = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// selfCertFingerprintRe matches the line a -selfcert proxy prints at startup,
+// e.g. "TLS Certificate fingerprint for ligolo is: 05E7...".
+var selfCertFingerprintRe = regexp.MustCompile(`(?i)certificate fingerprint for .* is: ?([0-9a-f]{64})\b`)
+
+// ProxyLogLine is one line of proxy output.
+type ProxyLogLine struct {
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	Text   string    `json:"text"`
+}
+
+// ProxyLog keeps recent proxy output in a ring buffer and appends it to a
+// size-rotated log file. Subscribers are woken whenever lines are added.
+type ProxyLog struct {
+	mu     sync.Mutex
+	lines  []ProxyLogLine
+	start  int
+	seq    uint64
+	notify chan struct{}
+
+	path string
+	file *os.File
+	size int64
+
+	fingerprint string
+}
+
+// ProxyLogDir returns the directory holding proxy log files.
+func ProxyLogDir() (string, error) {
+	base, err := DefaultAppDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "logs"), nil
+}
+
+// NewProxyLog creates a ProxyLog writing to <dir>/<name>.log. An empty dir
+// keeps output in memory only.
+func NewProxyLog(dir, name string) (*ProxyLog, error) {
+	l := &ProxyLog{
+		lines:  make([]ProxyLogLine, 0, defaultProxyLogLines),
+		notify: make(chan struct{}),
+	}
+	if dir == "" {
+		return l, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	l.path = filepath.Join(dir, name+".log")
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Path returns the current log file path, or "" for in-memory logs.
+func (l *ProxyLog) Path() string {
+	return l.path
+}
+
+func (l *ProxyLog) openFile() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = fi.Size()
+	return nil
+}
+
+// rotate shifts proxy.log -> proxy.log.1 -> ... and drops the oldest file.
+func (l *ProxyLog) rotate() error {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	rotateLogFiles(l.path, proxyLogKeepFiles)
+	return l.openFile()
+}
+
+// rotateLogFiles renames path to path.1, path.1 to path.2 and so on, keeping
+// at most keep old files.
+func rotateLogFiles(path string, keep int) {
+	for i := keep - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	_ = os.Rename(path, path+".1")
+}
+
+// Append records a line from the given stream ("stdout", "stderr" or "event").
+func (l *ProxyLog) Append(stream, text string) {
+	text = ansiEscapeRe.ReplaceAllString(text, "")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if m := selfCertFingerprintRe.FindStringSubmatch(text); m != nil {
+		l.fingerprint = strings.ToUpper(m[1])
+	}
+
+	l.seq++
+	line := ProxyLogLine{Seq: l.seq, Time: time.Now(), Stream: stream, Text: text}
+	if len(l.lines) < cap(l.lines) {
+		l.lines = append(l.lines, line)
+	} else {
+		l.lines[l.start] = line
+		l.start = (l.start + 1) % len(l.lines)
+	}
+
+	if l.path != "" {
+		if l.size >= proxyLogMaxBytes {
+			_ = l.rotate()
+		}
+		if l.file != nil {
+			n, _ := fmt.Fprintf(l.file, "%s [%s] %s\n", line.Time.Format(time.RFC3339), stream, text)
+			l.size += int64(n)
+		}
+	}
+
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// Since returns buffered lines with Seq greater than seq, plus a channel
+// that is closed when more lines arrive.
+func (l *ProxyLog) Since(seq uint64) ([]ProxyLogLine, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := []ProxyLogLine{}
+	for i := 0; i < len(l.lines); i++ {
+		line := l.lines[(l.start+i)%len(l.lines)]
+		if line.Seq > seq {
+			out = append(out, line)
+		}
+	}
+	return out, l.notify
+}
+
+// Fingerprint returns the TLS certificate fingerprint most recently reported
+// by a -selfcert proxy, or "" if none was seen.
+func (l *ProxyLog) Fingerprint() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fingerprint
+}
+
+// LastSeq returns the sequence number of the most recent line.
+func (l *ProxyLog) LastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// Tail returns up to n of the most recent lines.
+func (l *ProxyLog) Tail(n int) []ProxyLogLine {
+	lines, _ := l.Since(0)
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// Close closes the log file.
+func (l *ProxyLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Writer returns an io.Writer that splits output into lines for stream.
+func (l *ProxyLog) Writer(stream string) io.Writer {
+	return &proxyLogWriter{log: l, stream: stream}
+}
+
+type proxyLogWriter struct {
+	log     *ProxyLog
+	stream  string
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (w *proxyLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.log.Append(w.stream, string(bytes.TrimRight(w.partial[:i], "\r")))
+		w.partial = w.partial[i+1:]
+	}
+	// Prompts and progress bars never end in a newline; don't hold them forever.
+	if len(w.partial) > proxyLogMaxPartialLine {
+		w.log.Append(w.stream, string(w.partial))
+		w.partial = nil
+	}
+	return len(p), nil
+}
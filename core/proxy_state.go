@@ -0,0 +1,115 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// proxyTailInterval is how often proxy output files are polled.
+const proxyTailInterval = 250 * time.Millisecond
+
+// ProxyRunState is persisted while a proxy runs so that a restarted UI can
+// find and re-adopt it.
+type ProxyRunState struct {
+	PID       int       `json:"pid"`
+	Args      []string  `json:"args"`
+	Addr      string    `json:"addr"`
+	StartedAt time.Time `json:"started_at"`
+	// Fingerprint is that of the certificate the proxy was started with;
+	// empty for selfcert.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// ProxyRunDir returns the directory holding proxy state and output files.
+func ProxyRunDir() (string, error) {
+	base, err := DefaultAppDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "run"), nil
+}
+
+func writeProxyRunState(path string, st ProxyRunState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o600)
+}
+
+func readProxyRunState(path string) (ProxyRunState, error) {
+	var st ProxyRunState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(data, &st)
+	return st, err
+}
+
+// verifyProxyRunState checks that the recorded process is still the proxy we
+// started rather than an unrelated process that reused its PID. Where the
+// command line can't be read, the proxy's listen port must be accepting
+// connections instead.
+func verifyProxyRunState(st ProxyRunState) error {
+	if !processAlive(st.PID) {
+		return errors.New("process is gone")
+	}
+
+	cmdline, err := processCmdline(st.PID)
+	if err == nil {
+		// Scripts show up behind their interpreter, so match the tail.
+		if len(cmdline) < len(st.Args) || !slices.Equal(cmdline[len(cmdline)-len(st.Args):], st.Args) {
+			return errors.New("pid belongs to a different process")
+		}
+		return nil
+	}
+	if !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", st.Addr, time.Second)
+	if err != nil {
+		return errors.New("proxy port is not listening")
+	}
+	conn.Close()
+	return nil
+}
+
+// tailFile copies data appended to path from offset onwards into w until
+// stop is closed, then copies whatever is left and closes the returned
+// channel.
+func tailFile(path string, offset int64, w io.Writer, stop <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		f, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return
+		}
+
+		ticker := time.NewTicker(proxyTailInterval)
+		defer ticker.Stop()
+		for {
+			_, _ = io.Copy(w, f)
+			select {
+			case <-stop:
+				_, _ = io.Copy(w, f)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
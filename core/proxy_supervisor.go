@@ -0,0 +1,429 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ProxyState is the lifecycle state of a supervised proxy process.
+type ProxyState string
+
+const (
+	ProxyStateStopped  ProxyState = "stopped"
+	ProxyStateStarting ProxyState = "starting"
+	ProxyStateRunning  ProxyState = "running"
+	ProxyStateExited   ProxyState = "exited"
+	ProxyStateCrashed  ProxyState = "crashed"
+)
+
+const (
+	// proxyStartupGrace is how long a new proxy reports "starting".
+	proxyStartupGrace = 2 * time.Second
+	// proxyStableAfter resets the restart backoff once a proxy stays up this long.
+	proxyStableAfter    = time.Minute
+	proxyInitialBackoff = time.Second
+	proxyMaxBackoff     = time.Minute
+	proxyStderrTail     = 10
+	// proxyAdoptedPoll is how often an adopted proxy is checked for exit.
+	proxyAdoptedPoll = 500 * time.Millisecond
+)
+
+// ProxyStatus is a snapshot of the supervised proxy.
+type ProxyStatus struct {
+	State         ProxyState `json:"state"`
+	PID           int        `json:"pid,omitempty"`
+	Addr          string     `json:"addr,omitempty"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	UptimeSeconds int64      `json:"uptime_seconds"`
+	ExitCode      *int       `json:"exit_code,omitempty"`
+	ExitedAt      *time.Time `json:"exited_at,omitempty"`
+	ExitError     string     `json:"exit_error,omitempty"`
+	LastStderr    []string   `json:"last_stderr,omitempty"`
+	Restarts      int        `json:"restarts"`
+	NextRestartAt *time.Time `json:"next_restart_at,omitempty"`
+	// Adopted is set for a proxy started by a previous UI process.
+	Adopted bool `json:"adopted,omitempty"`
+}
+
+// Running reports whether the proxy process is alive.
+func (s ProxyStatus) Running() bool {
+	return s.State == ProxyStateStarting || s.State == ProxyStateRunning
+}
+
+// ProxySupervisor starts the ligolo proxy, waits on it in the background and
+// records how it ended. With Config.ProxyAutoRestart set, a crashed proxy is
+// restarted with exponential backoff.
+//
+// Proxy output goes to files in the run dir, which are tailed into the log,
+// and the PID is recorded in a state file. A proxy therefore outlives a UI
+// crash without dying on a broken pipe, and the next UI can Adopt it.
+type ProxySupervisor struct {
+	mu     sync.Mutex
+	name   string
+	log    *ProxyLog
+	runDir string
+
+	cfg         Config
+	pid         int
+	fingerprint string
+	done        chan struct{}
+	status      ProxyStatus
+	startSeq    uint64
+	stopping    bool
+	backoff     time.Duration
+	timer       *time.Timer
+}
+
+// ErrProxyRunning is returned by Start when the proxy is already running.
+var ErrProxyRunning = errors.New("proxy already running")
+
+// NewProxySupervisor returns a supervisor for the named proxy that sends its
+// output to log. If the run dir can't be created, output is piped directly
+// and the proxy can't be re-adopted after a UI restart.
+func NewProxySupervisor(name string, log *ProxyLog) *ProxySupervisor {
+	s := &ProxySupervisor{
+		name:   name,
+		log:    log,
+		status: ProxyStatus{State: ProxyStateStopped},
+	}
+	if dir, err := ProxyRunDir(); err == nil && os.MkdirAll(dir, 0o700) == nil {
+		s.runDir = dir
+	}
+	return s
+}
+
+func (s *ProxySupervisor) runPath(ext string) string {
+	return filepath.Join(s.runDir, s.name+ext)
+}
+
+// Start launches the proxy. It fails if one is already running.
+func (s *ProxySupervisor) Start(cfg Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pid != 0 {
+		return ErrProxyRunning
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.status.Restarts = 0
+	s.backoff = proxyInitialBackoff
+	return s.startLocked(cfg)
+}
+
+func (s *ProxySupervisor) startLocked(cfg Config) error {
+	cfg = SanitizeConfig(cfg)
+	s.startSeq = s.log.LastSeq()
+
+	var stdout, stderr io.Writer = s.log.Writer("stdout"), s.log.Writer("stderr")
+	files := s.runDir != ""
+	if files {
+		outF, err1 := os.OpenFile(s.runPath(".stdout"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		errF, err2 := os.OpenFile(s.runPath(".stderr"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err := errors.Join(err1, err2); err != nil {
+			return err
+		}
+		// The child keeps its own copies of the descriptors.
+		defer outF.Close()
+		defer errF.Close()
+		stdout, stderr = outF, errF
+	}
+
+	cmd, fingerprint, err := StartProxy(cfg, stdout, stderr)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	s.cfg = cfg
+	s.pid = cmd.Process.Pid
+	s.fingerprint = fingerprint
+	s.done = make(chan struct{})
+	s.stopping = false
+	s.status = ProxyStatus{
+		State:     ProxyStateStarting,
+		PID:       s.pid,
+		Addr:      fmt.Sprintf("%s:%d", cfg.ProxyBind, cfg.ProxyPort),
+		StartedAt: &now,
+		Restarts:  s.status.Restarts,
+	}
+	s.log.Append("event", fmt.Sprintf("proxy started (pid %d) on %s", s.status.PID, s.status.Addr))
+
+	var tails []<-chan struct{}
+	stop := make(chan struct{})
+	if files {
+		st := ProxyRunState{PID: s.pid, Args: cmd.Args, Addr: s.status.Addr, StartedAt: now, Fingerprint: fingerprint}
+		if err := writeProxyRunState(s.runPath(".json"), st); err != nil {
+			s.log.Append("event", "failed to record proxy state: "+err.Error())
+		}
+		tails = s.tailOutput(0, 0, stop)
+	}
+
+	go s.wait(cmd, s.done, stop, tails)
+	return nil
+}
+
+// Adopt re-attaches to a proxy left running by a previous UI process, as
+// recorded in the run state file, so that Stop and Status work again. It
+// reports whether a proxy was adopted. A stale state file is removed.
+func (s *ProxySupervisor) Adopt(cfg Config) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pid != 0 || s.runDir == "" {
+		return false, nil
+	}
+	st, err := readProxyRunState(s.runPath(".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err == nil {
+		if verr := verifyProxyRunState(st); verr != nil {
+			err = fmt.Errorf("stale proxy state for pid %d: %w", st.PID, verr)
+		}
+	}
+	if err != nil {
+		_ = os.Remove(s.runPath(".json"))
+		return false, err
+	}
+
+	startedAt := st.StartedAt
+	s.cfg = SanitizeConfig(cfg)
+	s.pid = st.PID
+	s.fingerprint = st.Fingerprint
+	s.done = make(chan struct{})
+	s.stopping = false
+	s.startSeq = s.log.LastSeq()
+	s.backoff = proxyInitialBackoff
+	s.status = ProxyStatus{
+		State:     ProxyStateRunning,
+		PID:       st.PID,
+		Addr:      st.Addr,
+		StartedAt: &startedAt,
+		Adopted:   true,
+	}
+	s.log.Append("event", fmt.Sprintf("re-attached to running proxy (pid %d) on %s", st.PID, st.Addr))
+
+	// Output written before the restart is already in the log file.
+	stop := make(chan struct{})
+	tails := s.tailOutput(fileSize(s.runPath(".stdout")), fileSize(s.runPath(".stderr")), stop)
+	go s.watch(st.PID, s.done, stop, tails)
+	return true, nil
+}
+
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+func (s *ProxySupervisor) tailOutput(outOffset, errOffset int64, stop <-chan struct{}) []<-chan struct{} {
+	return []<-chan struct{}{
+		tailFile(s.runPath(".stdout"), outOffset, s.log.Writer("stdout"), stop),
+		tailFile(s.runPath(".stderr"), errOffset, s.log.Writer("stderr"), stop),
+	}
+}
+
+// wait reaps a proxy started by this supervisor and records its exit.
+func (s *ProxySupervisor) wait(cmd *exec.Cmd, done, stop chan struct{}, tails []<-chan struct{}) {
+	err := cmd.Wait()
+	close(stop)
+	for _, t := range tails {
+		<-t
+	}
+
+	code := cmd.ProcessState.ExitCode()
+	reason := fmt.Sprintf("exit code %d", code)
+	if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		reason = fmt.Sprintf("signal %s", ws.Signal())
+	}
+	var exitErr string
+	if err != nil {
+		exitErr = err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finishLocked(done, &code, exitErr, reason)
+}
+
+// watch polls an adopted proxy, which can't be waited on, until it exits.
+// Its exit status is unknown.
+func (s *ProxySupervisor) watch(pid int, done, stop chan struct{}, tails []<-chan struct{}) {
+	ticker := time.NewTicker(proxyAdoptedPoll)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !processAlive(pid) {
+			break
+		}
+	}
+	close(stop)
+	for _, t := range tails {
+		<-t
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finishLocked(done, nil, "exit status unknown (proxy was re-adopted)", "exit status unknown")
+}
+
+// finishLocked records the end of the proxy run identified by done. A nil
+// code means the exit status is unknown, which counts as a crash unless the
+// proxy was being stopped.
+func (s *ProxySupervisor) finishLocked(done chan struct{}, code *int, exitErr, reason string) {
+	defer close(done)
+	if s.done != done {
+		return
+	}
+
+	if s.runDir != "" {
+		_ = os.Remove(s.runPath(".json"))
+	}
+
+	now := time.Now()
+	s.pid = 0
+	s.fingerprint = ""
+	s.status.ExitedAt = &now
+	s.status.ExitCode = code
+	s.status.ExitError = exitErr
+	s.status.LastStderr = s.stderrTail()
+
+	switch {
+	case s.stopping:
+		s.status.State = ProxyStateStopped
+		s.log.Append("event", "proxy stopped")
+		return
+	case code != nil && *code == 0:
+		s.status.State = ProxyStateExited
+		s.log.Append("event", "proxy exited")
+		return
+	}
+
+	s.status.State = ProxyStateCrashed
+	s.log.Append("event", fmt.Sprintf("proxy crashed (%s)", reason))
+	s.scheduleRestartLocked()
+}
+
+func (s *ProxySupervisor) scheduleRestartLocked() {
+	if !s.cfg.ProxyAutoRestart {
+		return
+	}
+	if s.cfg.ProxyMaxRestarts > 0 && s.status.Restarts >= s.cfg.ProxyMaxRestarts {
+		s.log.Append("event", fmt.Sprintf("proxy restart limit (%d) reached", s.cfg.ProxyMaxRestarts))
+		return
+	}
+
+	if s.status.StartedAt != nil && time.Since(*s.status.StartedAt) >= proxyStableAfter {
+		s.backoff = proxyInitialBackoff
+	}
+	delay := s.backoff
+	s.backoff *= 2
+	if s.backoff > proxyMaxBackoff {
+		s.backoff = proxyMaxBackoff
+	}
+
+	next := time.Now().Add(delay)
+	s.status.NextRestartAt = &next
+	s.log.Append("event", fmt.Sprintf("restarting proxy in %s", delay))
+
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.timer != timer || s.pid != 0 {
+			return
+		}
+		s.timer = nil
+		s.status.Restarts++
+		if err := s.startLocked(s.cfg); err != nil {
+			s.log.Append("event", "proxy restart failed: "+err.Error())
+			s.scheduleRestartLocked()
+		}
+	})
+	s.timer = timer
+}
+
+// stderrTail returns the last stderr lines written since the proxy started.
+func (s *ProxySupervisor) stderrTail() []string {
+	lines, _ := s.log.Since(s.startSeq)
+	tail := []string{}
+	for _, l := range lines {
+		if l.Stream == "stderr" {
+			tail = append(tail, l.Text)
+		}
+	}
+	if len(tail) > proxyStderrTail {
+		tail = tail[len(tail)-proxyStderrTail:]
+	}
+	return tail
+}
+
+// Stop sends SIGTERM to the proxy's process group so ligolo can tear down
+// its tunnels, kills it if it hasn't exited after processStopGrace, and
+// cancels any pending restart. It reports whether a proxy was running.
+func (s *ProxySupervisor) Stop() bool {
+	s.mu.Lock()
+	cancelled := false
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+		s.status.NextRestartAt = nil
+		cancelled = true
+	}
+	pid, done := s.pid, s.done
+	if pid == 0 {
+		if cancelled {
+			s.status.State = ProxyStateStopped
+		}
+		s.mu.Unlock()
+		return false
+	}
+	s.stopping = true
+	s.mu.Unlock()
+
+	stopProcessGroup(pid, done, processStopGrace)
+	return true
+}
+
+// Fingerprint returns the fingerprint of the certificate the running proxy
+// serves: the one it was started with, or the one a selfcert proxy reported.
+// It is "" when no proxy is running.
+func (s *ProxySupervisor) Fingerprint() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pid == 0 {
+		return ""
+	}
+	if s.fingerprint != "" {
+		return s.fingerprint
+	}
+	return s.log.Fingerprint()
+}
+
+// Status returns a snapshot of the proxy state.
+func (s *ProxySupervisor) Status() ProxyStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.status
+	st.LastStderr = append([]string(nil), s.status.LastStderr...)
+	if s.pid != 0 && st.StartedAt != nil {
+		up := time.Since(*st.StartedAt)
+		st.UptimeSeconds = int64(up.Seconds())
+		if up >= proxyStartupGrace {
+			st.State = ProxyStateRunning
+		}
+	}
+	return st
+}
@@ -2,23 +2,143 @@ package core
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 const (
-	// Ligolo-ng v0.8.2 download URLs for Linux amd64.
-	LigoloVersion            = "v0.8.2"
-	LigoloProxyURLLinuxAmd64 = "https://github.com/nicocha30/ligolo-ng/releases/download/v0.8.2/ligolo-ng_proxy_0.8.2_linux_amd64.tar.gz"
-	LigoloAgentURLLinuxAmd64 = "https://github.com/nicocha30/ligolo-ng/releases/download/v0.8.2/ligolo-ng_agent_0.8.2_linux_amd64.tar.gz"
+	// LigoloVersion is the Ligolo-ng release installed by Skiddie Mode.
+	LigoloVersion = "v0.8.2"
 )
 
+var (
+	// ErrSkiddieUnsupported is returned when installing on a host other
+	// than Linux.
+	ErrSkiddieUnsupported = errors.New("Skiddie Mode is supported on Linux only")
+	// ErrChecksumMismatch is returned when an archive doesn't match its
+	// published SHA-256.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrInvalidArchive is returned for release files that aren't an
+	// installable archive for this host, or are missing their binary.
+	ErrInvalidArchive = errors.New("invalid release archive")
+)
+
+// SkiddieSupported reports whether Skiddie Mode can install on this host.
+func SkiddieSupported() error {
+	if runtime.GOOS != "linux" {
+		return ErrSkiddieUnsupported
+	}
+	return nil
+}
+
+// ligoloReleaseBaseURL is the prefix for release asset downloads.
+var ligoloReleaseBaseURL = "https://github.com/nicocha30/ligolo-ng/releases/download"
+
+// LigoloPlatform identifies a Ligolo-ng release build (e.g. linux/amd64).
+type LigoloPlatform struct {
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+}
+
+// ligoloPlatforms lists every OS/arch combination published in Ligolo-ng releases.
+var ligoloPlatforms = []LigoloPlatform{
+	{"linux", "amd64"},
+	{"linux", "arm64"},
+	{"linux", "armv7"},
+	{"linux", "386"},
+	{"windows", "amd64"},
+	{"windows", "arm64"},
+	{"windows", "386"},
+	{"darwin", "amd64"},
+	{"darwin", "arm64"},
+}
+
+// DefaultAgentPlatforms returns the agent builds Skiddie Mode fetches when none are configured.
+func DefaultAgentPlatforms() []string {
+	return []string{"linux/amd64", "linux/arm64", "linux/386", "windows/amd64", "windows/386", "darwin/amd64", "darwin/arm64"}
+}
+
+// ParseLigoloPlatform parses an "os/arch" string into a known LigoloPlatform.
+func ParseLigoloPlatform(s string) (LigoloPlatform, error) {
+	parts := strings.SplitN(strings.ToLower(strings.TrimSpace(s)), "/", 2)
+	if len(parts) != 2 {
+		return LigoloPlatform{}, fmt.Errorf("invalid platform %q (expected os/arch)", s)
+	}
+	for _, p := range ligoloPlatforms {
+		if p.OS == parts[0] && p.Arch == parts[1] {
+			return p, nil
+		}
+	}
+	return LigoloPlatform{}, fmt.Errorf("unsupported platform %q", s)
+}
+
+func (p LigoloPlatform) String() string {
+	return p.OS + "/" + p.Arch
+}
+
+// ArchiveExt returns the release archive extension for the platform.
+func (p LigoloPlatform) ArchiveExt() string {
+	if p.OS == "windows" {
+		return ".zip"
+	}
+	return ".tar.gz"
+}
+
+// BinaryName returns the executable name inside the release archive.
+func (p LigoloPlatform) BinaryName(component string) string {
+	if p.OS == "windows" {
+		return component + ".exe"
+	}
+	return component
+}
+
+// AgentFilename returns the per-platform file name used in LigoloInstallDir.
+func (p LigoloPlatform) AgentFilename() string {
+	name := fmt.Sprintf("agent_%s_%s", p.OS, p.Arch)
+	if p.OS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// ligoloHostPlatform returns the platform matching the machine running the proxy.
+func ligoloHostPlatform() (LigoloPlatform, error) {
+	arch := runtime.GOARCH
+	if arch == "arm" {
+		arch = "armv7"
+	}
+	return ParseLigoloPlatform(runtime.GOOS + "/" + arch)
+}
+
+// LigoloAssetName returns the release archive file name for a component ("proxy" or "agent").
+func LigoloAssetName(version, component string, p LigoloPlatform) string {
+	bare := strings.TrimPrefix(version, "v")
+	return fmt.Sprintf("ligolo-ng_%s_%s_%s_%s%s", component, bare, p.OS, p.Arch, p.ArchiveExt())
+}
+
+// LigoloAssetURL builds the download URL for a component under a release base URL.
+func LigoloAssetURL(base, version, component string, p LigoloPlatform) string {
+	return base + "/" + version + "/" + LigoloAssetName(version, component, p)
+}
+
+// ligoloBaseURL returns the configured mirror, or the upstream release URL.
+func ligoloBaseURL(cfg Config) string {
+	if cfg.LigoloMirror != "" {
+		return strings.TrimRight(cfg.LigoloMirror, "/")
+	}
+	return ligoloReleaseBaseURL
+}
+
 // LigoloInstallDir returns the default install directory for ligolo binaries.
 func LigoloInstallDir() (string, error) {
 	base, err := DefaultAppDataDir()
@@ -28,13 +148,61 @@ func LigoloInstallDir() (string, error) {
 	return filepath.Join(base, "ligolo"), nil
 }
 
+// LigoloVersionDir returns the side-by-side install directory for a release.
+func LigoloVersionDir(version string) (string, error) {
+	if err := ValidateLigoloVersion(version); err != nil {
+		return "", err
+	}
+	installDir, err := LigoloInstallDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(installDir, version), nil
+}
+
+// LigoloAgentVariant describes one per-platform agent build in the install dir.
+type LigoloAgentVariant struct {
+	Platform string `json:"platform"`
+	Filename string `json:"filename"`
+	Present  bool   `json:"present"`
+}
+
 // LigoloStatus indicates whether ligolo binaries are present and configured.
 type LigoloStatus struct {
-	Installed  bool   `json:"installed"`
-	ProxyPath  string `json:"proxy_path"`
-	AgentName  string `json:"agent_name"`
-	InstallDir string `json:"install_dir"`
-	Reason     string `json:"reason,omitempty"`
+	Installed  bool                 `json:"installed"`
+	ProxyPath  string               `json:"proxy_path"`
+	AgentName  string               `json:"agent_name"`
+	InstallDir string               `json:"install_dir"`
+	Version    string               `json:"version"`
+	ActiveDir  string               `json:"active_dir"`
+	Agents     []LigoloAgentVariant `json:"agents"`
+	Reason     string               `json:"reason,omitempty"`
+}
+
+// ligoloActiveDir returns the directory of the active install. Installs
+// predating versioned subdirectories live directly in installDir.
+func ligoloActiveDir(cfg Config, installDir string) string {
+	if cfg.LigoloVersion != "" {
+		return filepath.Join(installDir, cfg.LigoloVersion)
+	}
+	return installDir
+}
+
+// agentVariants reports which configured agent builds exist in installDir.
+func agentVariants(cfg Config, installDir string) []LigoloAgentVariant {
+	variants := []LigoloAgentVariant{}
+	for _, spec := range cfg.AgentPlatforms {
+		p, err := ParseLigoloPlatform(spec)
+		if err != nil {
+			continue
+		}
+		v := LigoloAgentVariant{Platform: p.String(), Filename: p.AgentFilename()}
+		if _, err := os.Stat(filepath.Join(installDir, v.Filename)); err == nil {
+			v.Present = true
+		}
+		variants = append(variants, v)
+	}
+	return variants
 }
 
 // CheckLigoloInstalled checks config and filesystem to see if ligolo is ready.
@@ -50,11 +218,16 @@ func CheckLigoloInstalled() (LigoloStatus, error) {
 		return LigoloStatus{}, err
 	}
 
+	activeDir := ligoloActiveDir(cfg, installDir)
+
 	status := LigoloStatus{
 		Installed:  false,
 		ProxyPath:  cfg.ProxyBinary,
 		AgentName:  cfg.AgentBinary,
 		InstallDir: installDir,
+		Version:    cfg.LigoloVersion,
+		ActiveDir:  activeDir,
+		Agents:     agentVariants(cfg, activeDir),
 	}
 
 	if cfg.ProxyBinary == "" {
@@ -67,7 +240,7 @@ func CheckLigoloInstalled() (LigoloStatus, error) {
 		return status, nil
 	}
 
-	agentPath := filepath.Join(installDir, cfg.AgentBinary)
+	agentPath := filepath.Join(activeDir, cfg.AgentBinary)
 	if _, err := os.Stat(agentPath); err != nil {
 		status.Reason = "agent binary not found in install dir"
 		return status, nil
@@ -78,77 +251,194 @@ func CheckLigoloInstalled() (LigoloStatus, error) {
 	return status, nil
 }
 
-func downloadAndExtractTarGz(url, destDir, destFilename string) error {
-	resp, err := http.Get(url)
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// extractArchive extracts member from a tar.gz or zip archive on disk into
+// destDir/destFilename. The archive type is chosen from the file suffix.
+func extractArchive(path, member, destDir, destFilename string) error {
+	if strings.HasSuffix(path, ".zip") {
+		return extractFromZip(path, member, destDir, destFilename)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return extractFromTarGz(f, member, destDir, destFilename)
+}
+
+// ligoloRelease identifies where a release is fetched from and the
+// published checksums its archives must match.
+type ligoloRelease struct {
+	base     string
+	version  string
+	sums     map[string]string
+	progress ProgressFunc
+}
+
+// installAsset installs one release asset into destDir, downloading it into
+// the local cache first if needed. The archive must match the published
+// checksum; a cached copy that doesn't is downloaded again. The verified hash
+// is recorded in result.
+func (rel ligoloRelease) installAsset(ctx context.Context, result *SkiddieResult, component string, p LigoloPlatform, destDir, destFilename string) error {
+	asset := LigoloAssetName(rel.version, component, p)
+	want, err := expectedSHA256(rel.sums, asset)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed: %s", resp.Status)
+	cachePath, err := ligoloCachePath(rel.version, asset)
+	if err != nil {
+		return err
+	}
+	assetURL := LigoloAssetURL(rel.base, rel.version, component, p)
+	_, err = os.Stat(cachePath)
+	cached := err == nil
+	if !cached {
+		if err := downloadFile(ctx, assetURL, cachePath, rel.progress); err != nil {
+			return err
+		}
 	}
 
-	gz, err := gzip.NewReader(resp.Body)
+	rel.progress.report(SkiddieProgress{Stage: "verify", Asset: asset})
+	sum, err := fileSHA256(cachePath)
 	if err != nil {
 		return err
 	}
+	if !strings.EqualFold(sum, want) && cached {
+		// The cached copy may be an unverified import or damaged: fetch
+		// it again once.
+		_ = os.Remove(cachePath)
+		if err := downloadFile(ctx, assetURL, cachePath, rel.progress); err != nil {
+			return err
+		}
+		if sum, err = fileSHA256(cachePath); err != nil {
+			return err
+		}
+	}
+	if !strings.EqualFold(sum, want) {
+		_ = os.Remove(cachePath)
+		return fmt.Errorf("%w for %s: got %s, want %s", ErrChecksumMismatch, asset, sum, want)
+	}
+
+	rel.progress.report(SkiddieProgress{Stage: "extract", Asset: asset, Message: destFilename})
+	if err := extractArchive(cachePath, p.BinaryName(component), destDir, destFilename); err != nil {
+		return err
+	}
+	result.Checksums[asset] = sum
+	return nil
+}
+
+func extractFromTarGz(r io.Reader, member, destDir, destFilename string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
 	defer gz.Close()
 
 	tr := tar.NewReader(gz)
-
-	targetPath := filepath.Join(destDir, destFilename)
 	for {
 		hdr, err := tr.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return err
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
 		}
 
 		if hdr.Typeflag != tar.TypeReg {
 			continue
 		}
 
-		if filepath.Base(hdr.Name) != destFilename {
+		if filepath.Base(hdr.Name) != member {
 			continue
 		}
 
-		if err := os.MkdirAll(destDir, 0o755); err != nil {
-			return err
-		}
+		return writeExecutable(tr, destDir, destFilename)
+	}
 
-		out, err := os.Create(targetPath)
-		if err != nil {
-			return err
-		}
-		if _, err := io.Copy(out, tr); err != nil {
-			out.Close()
-			return err
+	return fmt.Errorf("%w: file %s not found in tar.gz", ErrInvalidArchive, member)
+}
+
+func extractFromZip(path, member, destDir, destFilename string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || filepath.Base(f.Name) != member {
+			continue
 		}
-		out.Close()
-		if err := os.Chmod(targetPath, 0o755); err != nil {
+		rc, err := f.Open()
+		if err != nil {
 			return err
 		}
-		return nil
+		defer rc.Close()
+		return writeExecutable(rc, destDir, destFilename)
 	}
 
-	return fmt.Errorf("file %s not found in tar.gz", destFilename)
+	return fmt.Errorf("%w: file %s not found in zip", ErrInvalidArchive, member)
+}
+
+func writeExecutable(r io.Reader, destDir, destFilename string) error {
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return err
+	}
+
+	targetPath := filepath.Join(destDir, destFilename)
+	out, err := os.Create(targetPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(targetPath, 0o755)
 }
 
 // SkiddieResult provides installer outcome details.
 type SkiddieResult struct {
-	InstalledBefore bool   `json:"installed_before"`
-	ProxyPath       string `json:"proxy_path"`
-	AgentName       string `json:"agent_name"`
-	Message         string `json:"message"`
+	InstalledBefore bool     `json:"installed_before"`
+	ProxyPath       string   `json:"proxy_path"`
+	AgentName       string   `json:"agent_name"`
+	Version         string   `json:"version"`
+	Agents          []string `json:"agents"`
+	// Checksums maps each installed release asset to its verified SHA-256.
+	Checksums map[string]string `json:"checksums"`
+	Message   string            `json:"message"`
+	// Published lists agent builds copied into the file server directory.
+	Published []PublishedAgent `json:"published,omitempty"`
 }
 
 // RunSkiddieInstall installs ligolo binaries for Linux and updates config.
-func RunSkiddieInstall() (SkiddieResult, error) {
-	if runtime.GOOS != "linux" {
-		return SkiddieResult{}, errors.New("Skiddie Mode is supported on Linux only")
+// The proxy matches the host architecture; agents are fetched for every
+// platform listed in Config.AgentPlatforms. An empty version keeps the
+// active install (or LigoloVersion on a fresh box). Any other version is
+// installed side-by-side under LigoloVersionDir and switched to, rolling
+// back if the new proxy fails its smoke test. Cancelling ctx aborts any
+// download in progress; a later run resumes it. progress may be nil.
+func RunSkiddieInstall(ctx context.Context, version string, progress ProgressFunc) (SkiddieResult, error) {
+	if err := SkiddieSupported(); err != nil {
+		return SkiddieResult{}, err
 	}
 
 	status, err := CheckLigoloInstalled()
@@ -156,53 +446,98 @@ func RunSkiddieInstall() (SkiddieResult, error) {
 		return SkiddieResult{}, err
 	}
 
+	cfg, err := LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return SkiddieResult{}, err
+	}
+
 	result := SkiddieResult{
 		InstalledBefore: status.Installed,
 		ProxyPath:       status.ProxyPath,
 		AgentName:       status.AgentName,
+		Version:         status.Version,
+		Agents:          []string{},
+		Checksums:       map[string]string{},
 	}
 
-	if status.Installed {
-		result.Message = "Ligolo-ng already installed and configured."
-		return result, nil
+	upgrade := true
+	if version == "" || version == status.Version {
+		version = status.Version
+		upgrade = !status.Installed
+	}
+	if version == "" {
+		version = LigoloVersion
+	}
+	if err := ValidateLigoloVersion(version); err != nil {
+		return result, err
 	}
 
-	installDir := status.InstallDir
-	if err := os.MkdirAll(installDir, 0o755); err != nil {
+	destDir := status.ActiveDir
+	if upgrade {
+		if destDir, err = LigoloVersionDir(version); err != nil {
+			return result, err
+		}
+	}
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
 		return result, err
 	}
 
-	proxyPath := filepath.Join(installDir, "proxy")
-	agentPath := filepath.Join(installDir, "agent")
+	missing := []LigoloPlatform{}
+	for _, v := range agentVariants(cfg, destDir) {
+		if v.Present {
+			continue
+		}
+		p, err := ParseLigoloPlatform(v.Platform)
+		if err != nil {
+			return result, err
+		}
+		missing = append(missing, p)
+	}
 
-	if err := downloadAndExtractTarGz(LigoloProxyURLLinuxAmd64, installDir, "proxy"); err != nil {
-		return result, fmt.Errorf("failed to install proxy: %w", err)
+	if !upgrade && len(missing) == 0 {
+		result.Message = "Ligolo-ng already installed and configured."
+		return result, nil
 	}
-	if err := downloadAndExtractTarGz(LigoloAgentURLLinuxAmd64, installDir, "agent"); err != nil {
-		return result, fmt.Errorf("failed to install agent: %w", err)
+
+	rel := ligoloRelease{base: ligoloBaseURL(cfg), version: version, progress: progress}
+	progress.report(SkiddieProgress{Stage: "checksums", Message: "fetching checksums for " + version})
+	rel.sums, err = FetchLigoloChecksums(ctx, rel.base, version)
+	if err != nil {
+		return result, fmt.Errorf("failed to fetch release checksums: %w", err)
 	}
 
-	if err := os.Chmod(proxyPath, 0o755); err != nil {
-		return result, err
+	for _, p := range missing {
+		if err := rel.installAsset(ctx, &result, "agent", p, destDir, p.AgentFilename()); err != nil {
+			return result, fmt.Errorf("failed to install agent %s: %w", p, err)
+		}
+		result.Agents = append(result.Agents, p.String())
 	}
-	if err := os.Chmod(agentPath, 0o755); err != nil {
-		return result, err
+
+	if !upgrade {
+		result.Message = fmt.Sprintf("Ligolo-ng already installed; added %d agent build(s).", len(result.Agents))
+		return result, nil
 	}
 
-	cfg, err := LoadConfig()
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
+	host, err := ligoloHostPlatform()
+	if err != nil {
 		return result, err
 	}
-	cfg.ProxyBinary = proxyPath
-	cfg.AgentBinary = "agent"
-	cfg = SanitizeConfig(cfg)
 
-	if err := SaveConfig(cfg); err != nil {
+	if err := rel.installAsset(ctx, &result, "proxy", host, destDir, "proxy"); err != nil {
+		return result, fmt.Errorf("failed to install proxy: %w", err)
+	}
+	if err := rel.installAsset(ctx, &result, "agent", host, destDir, "agent"); err != nil {
+		return result, fmt.Errorf("failed to install agent: %w", err)
+	}
+
+	progress.report(SkiddieProgress{Stage: "switch", Message: "smoke testing proxy " + version})
+	if err := SwitchLigoloVersion(version); err != nil {
 		return result, err
 	}
 
-	result.ProxyPath = proxyPath
+	result.ProxyPath = filepath.Join(destDir, "proxy")
 	result.AgentName = "agent"
-	result.Message = "Ligolo-ng installed and config updated."
+	result.Version = version
+	result.Message = fmt.Sprintf("Ligolo-ng %s installed and config updated.", version)
 	return result, nil
 }
@@ -0,0 +1,284 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProxyScript is a proxy "binary" that passes the -version smoke test.
+const fakeProxyScript = "#!/bin/sh\necho ligolo-ng fake\n"
+
+// fakeLigoloRelease serves release archives for every platform of one
+// version, plus their checksums.txt, in place of GitHub.
+type fakeLigoloRelease struct {
+	version  string
+	assets   map[string][]byte
+	sums     string
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newFakeLigoloRelease(t *testing.T, version string) *fakeLigoloRelease {
+	t.Helper()
+	rel := &fakeLigoloRelease{version: version, assets: map[string][]byte{}, requests: map[string]int{}}
+	for _, p := range ligoloPlatforms {
+		for _, component := range []string{"proxy", "agent"} {
+			content := component + " " + p.String()
+			if component == "proxy" {
+				content = fakeProxyScript
+			}
+			name := LigoloAssetName(version, component, p)
+			rel.assets[name] = fakeLigoloArchive(t, component, p, content)
+			rel.sums += checksumLine(name, rel.assets[name])
+		}
+	}
+
+	srv := httptest.NewServer(rel)
+	t.Cleanup(srv.Close)
+	old := ligoloReleaseBaseURL
+	ligoloReleaseBaseURL = srv.URL
+	t.Cleanup(func() { ligoloReleaseBaseURL = old })
+	return rel
+}
+
+func (rel *fakeLigoloRelease) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dir, name := path.Split(r.URL.Path)
+	rel.mu.Lock()
+	rel.requests[name]++
+	rel.mu.Unlock()
+	if dir != "/"+rel.version+"/" {
+		http.NotFound(w, r)
+		return
+	}
+	if name == "ligolo-ng_"+strings.TrimPrefix(rel.version, "v")+"_checksums.txt" {
+		w.Write([]byte(rel.sums))
+		return
+	}
+	data, ok := rel.assets[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+func (rel *fakeLigoloRelease) count(name string) int {
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	return rel.requests[name]
+}
+
+func TestLigoloAssetNames(t *testing.T) {
+	tests := []struct {
+		platform  string
+		component string
+		asset     string
+		member    string
+		agentFile string
+	}{
+		{"linux/amd64", "proxy", "ligolo-ng_proxy_0.8.2_linux_amd64.tar.gz", "proxy", "agent_linux_amd64"},
+		{"linux/armv7", "agent", "ligolo-ng_agent_0.8.2_linux_armv7.tar.gz", "agent", "agent_linux_armv7"},
+		{"windows/amd64", "agent", "ligolo-ng_agent_0.8.2_windows_amd64.zip", "agent.exe", "agent_windows_amd64.exe"},
+		{"windows/386", "proxy", "ligolo-ng_proxy_0.8.2_windows_386.zip", "proxy.exe", "agent_windows_386.exe"},
+		{"darwin/arm64", "agent", "ligolo-ng_agent_0.8.2_darwin_arm64.tar.gz", "agent", "agent_darwin_arm64"},
+	}
+	for _, tt := range tests {
+		p, err := ParseLigoloPlatform(tt.platform)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := LigoloAssetName("v0.8.2", tt.component, p); got != tt.asset {
+			t.Errorf("LigoloAssetName(%s, %s) = %q, want %q", tt.platform, tt.component, got, tt.asset)
+		}
+		if got := p.BinaryName(tt.component); got != tt.member {
+			t.Errorf("%s BinaryName(%s) = %q, want %q", tt.platform, tt.component, got, tt.member)
+		}
+		if got := p.AgentFilename(); got != tt.agentFile {
+			t.Errorf("%s AgentFilename() = %q, want %q", tt.platform, got, tt.agentFile)
+		}
+		asset, err := parseLigoloAssetName(tt.asset)
+		if err != nil || asset.Platform != p || asset.Component != tt.component || asset.Version != "v0.8.2" {
+			t.Errorf("parseLigoloAssetName(%q) = %+v, %v", tt.asset, asset, err)
+		}
+	}
+	if got := LigoloAssetURL("https://mirror/dl", "v0.8.2", "agent", LigoloPlatform{"linux", "386"}); got != "https://mirror/dl/v0.8.2/ligolo-ng_agent_0.8.2_linux_386.tar.gz" {
+		t.Errorf("LigoloAssetURL = %q", got)
+	}
+	for _, bad := range []string{"ligolo-ng_agent_0.8.2_windows_amd64.tar.gz", "ligolo-ng_agent_0.8.2_plan9_amd64.tar.gz", "agent_0.8.2_linux_amd64.tar.gz"} {
+		if _, err := parseLigoloAssetName(bad); err == nil {
+			t.Errorf("parseLigoloAssetName(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	dir := t.TempDir()
+	for _, platform := range []string{"linux/amd64", "windows/amd64"} {
+		p, _ := ParseLigoloPlatform(platform)
+		archive := filepath.Join(dir, LigoloAssetName("v0.8.2", "agent", p))
+		writeTestFile(t, archive, string(fakeLigoloArchive(t, "agent", p, "agent "+platform)))
+
+		if err := extractArchive(archive, p.BinaryName("agent"), dir, p.AgentFilename()); err != nil {
+			t.Fatalf("%s: %v", platform, err)
+		}
+		out := filepath.Join(dir, p.AgentFilename())
+		if data, _ := os.ReadFile(out); string(data) != "agent "+platform {
+			t.Errorf("%s: extracted %q", platform, data)
+		}
+		if fi, err := os.Stat(out); err != nil || fi.Mode().Perm()&0o100 == 0 {
+			t.Errorf("%s: extracted file not executable: %v", platform, err)
+		}
+		if err := extractArchive(archive, "proxy", dir, "proxy"); !errors.Is(err, ErrInvalidArchive) {
+			t.Errorf("%s: extracting a missing member succeeded", platform)
+		}
+	}
+}
+
+func TestRunSkiddieInstall(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skiddie Mode is Linux only")
+	}
+	t.Setenv("HOME", t.TempDir())
+	rel := newFakeLigoloRelease(t, "v0.9.0")
+	host, err := ligoloHostPlatform()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.AgentPlatforms = []string{"windows/amd64", "darwin/arm64"}
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	stages := map[string]bool{}
+	result, err := RunSkiddieInstall(context.Background(), "v0.9.0", func(p SkiddieProgress) { stages[p.Stage] = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	versionDir, _ := LigoloVersionDir("v0.9.0")
+	if data, _ := os.ReadFile(filepath.Join(versionDir, "proxy")); string(data) != fakeProxyScript {
+		t.Errorf("installed proxy = %q", data)
+	}
+	for _, p := range []LigoloPlatform{{"windows", "amd64"}, {"darwin", "arm64"}} {
+		if data, _ := os.ReadFile(filepath.Join(versionDir, p.AgentFilename())); string(data) != "agent "+p.String() {
+			t.Errorf("agent %s = %q", p, data)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(versionDir, "agent")); string(data) != "agent "+host.String() {
+		t.Errorf("host agent = %q", data)
+	}
+	for _, stage := range []string{"checksums", "download", "verify", "extract", "switch"} {
+		if !stages[stage] {
+			t.Errorf("no %q progress reported", stage)
+		}
+	}
+	if len(result.Checksums) != 4 || result.Version != "v0.9.0" {
+		t.Errorf("result = %+v", result)
+	}
+	saved, _ := LoadConfig()
+	if saved.LigoloVersion != "v0.9.0" || saved.ProxyBinary != filepath.Join(versionDir, "proxy") {
+		t.Errorf("config not switched: %q %q", saved.LigoloVersion, saved.ProxyBinary)
+	}
+
+	// Reinstalling uses the cached archives.
+	agent := LigoloAssetName("v0.9.0", "agent", LigoloPlatform{"windows", "amd64"})
+	os.Remove(filepath.Join(versionDir, LigoloPlatform{"windows", "amd64"}.AgentFilename()))
+	if _, err := RunSkiddieInstall(context.Background(), "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := rel.count(agent); n != 1 {
+		t.Errorf("%s downloaded %d times, want the cached copy reused", agent, n)
+	}
+
+	// A tampered archive is refused and dropped from the cache.
+	proxyAsset := LigoloAssetName("v0.9.0", "proxy", host)
+	rel.assets[proxyAsset] = append([]byte{}, rel.assets[proxyAsset]...)
+	rel.assets[proxyAsset][len(rel.assets[proxyAsset])-1] ^= 0xff
+	cached, _ := ligoloCachePath("v0.9.0", proxyAsset)
+	os.Remove(cached)
+	os.RemoveAll(versionDir)
+	if _, err := RunSkiddieInstall(context.Background(), "v0.9.0", nil); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("install of a tampered proxy = %v", err)
+	}
+	if _, err := os.Stat(cached); !os.IsNotExist(err) {
+		t.Errorf("tampered archive left in the cache: %v", err)
+	}
+}
+
+func TestSwitchLigoloVersionSmokeTest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script proxy")
+	}
+	t.Setenv("HOME", t.TempDir())
+	for version, script := range map[string]string{"v0.1.0": fakeProxyScript, "v0.2.0": "#!/bin/sh\necho unsupported >&2\nexit 1\n"} {
+		dir, _ := LigoloVersionDir(version)
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(dir, "proxy"), script)
+		if err := os.Chmod(filepath.Join(dir, "proxy"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SwitchLigoloVersion("v0.1.0"); err != nil {
+		t.Fatal(err)
+	}
+	err := SwitchLigoloVersion("v0.2.0")
+	if !errors.Is(err, ErrSmokeTest) || !strings.Contains(err.Error(), "unsupported") {
+		t.Fatalf("switch to a broken proxy = %v, want ErrSmokeTest", err)
+	}
+	if cfg, _ := LoadConfig(); cfg.LigoloVersion != "v0.1.0" {
+		t.Errorf("active version %q after a failed switch, want v0.1.0", cfg.LigoloVersion)
+	}
+}
+
+func TestRunSkiddieInstallReplacesUnverifiedImport(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skiddie Mode is Linux only")
+	}
+	t.Setenv("HOME", t.TempDir())
+	rel := newFakeLigoloRelease(t, "v0.9.1")
+	if _, err := ligoloHostPlatform(); err != nil {
+		t.Skip(err)
+	}
+	cfg := DefaultConfig()
+	cfg.AgentPlatforms = []string{"windows/amd64"}
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// An archive imported without checksums sits in the cache unverified.
+	win := LigoloPlatform{"windows", "amd64"}
+	name := LigoloAssetName("v0.9.1", "agent", win)
+	path, err := ImportLigoloFile(name, bytes.NewReader(fakeLigoloArchive(t, "agent", win, "MZ imported")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An online install replaces it with the published archive.
+	if _, err := RunSkiddieInstall(context.Background(), "v0.9.1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := rel.count(name); n != 1 {
+		t.Errorf("%s downloaded %d times, want 1", name, n)
+	}
+	versionDir, _ := LigoloVersionDir("v0.9.1")
+	if data, _ := os.ReadFile(filepath.Join(versionDir, win.AgentFilename())); string(data) != "agent windows/amd64" {
+		t.Errorf("installed agent = %q", data)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, rel.assets[name]) {
+		t.Error("cache still holds the unverified import")
+	}
+}
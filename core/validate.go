@@ -0,0 +1,252 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// FieldError is a validation failure for one config field, named by its JSON
+// key (e.g. "proxy_port" or "proxy_instances[1].port").
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// FieldErrors collects the field errors found by ValidateConfig.
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	parts := make([]string, 0, len(e))
+	for _, fe := range e {
+		parts = append(parts, fe.Field+": "+fe.Message)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (e *FieldErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Only returns the errors for the given top-level fields, so a partial update
+// isn't rejected for settings it didn't touch.
+func (e FieldErrors) Only(fields map[string]bool) FieldErrors {
+	out := FieldErrors{}
+	for _, fe := range e {
+		top, _, _ := strings.Cut(fe.Field, "[")
+		if fields[top] {
+			out = append(out, fe)
+		}
+	}
+	return out
+}
+
+var hostnameRe = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,62})(\.[A-Za-z0-9-]{1,63})*\.?$`)
+
+// ValidateConfig checks cfg as entered, before SanitizeConfig fills in
+// defaults, and reports every invalid field. Surrounding whitespace is
+// ignored.
+func ValidateConfig(cfg Config) FieldErrors {
+	errs := FieldErrors{}
+
+	publicIP := strings.TrimSpace(cfg.PublicIP)
+	iface, isIface := PublicIPInterface(publicIP)
+	switch {
+	case publicIP == "":
+		errs.add("public_ip", "is required (IP, domain or iface:<interface>)")
+	case isIface:
+		if iface == "" || strings.ContainsAny(iface, "/:") {
+			errs.add("public_ip", "%q does not name an interface", publicIP)
+		}
+	case publicIP == defaultPublicIP, net.ParseIP(publicIP) != nil:
+	case !hostnameRe.MatchString(publicIP):
+		errs.add("public_ip", "%q is not an IP address, domain or iface:<interface>", publicIP)
+	}
+
+	validateBindIP(&errs, "proxy_bind", cfg.ProxyBind, true)
+	validatePort(&errs, "proxy_port", cfg.ProxyPort)
+	// The default path is a placeholder until Skiddie Mode installs a proxy.
+	if strings.TrimSpace(cfg.ProxyBinary) != defaultProxyBinary {
+		validateExecutable(&errs, "proxy_binary", cfg.ProxyBinary, true)
+	}
+
+	agent := strings.TrimSpace(cfg.AgentBinary)
+	if agent == "" {
+		errs.add("agent_binary", "is required")
+	} else if strings.ContainsAny(agent, `/\`) {
+		errs.add("agent_binary", "must be a file name, not a path")
+	}
+
+	certMode := strings.TrimSpace(cfg.ProxyCertMode)
+	switch certMode {
+	case "", CertModeSelfCert, CertModeLocalCA, CertModeCustom:
+	default:
+		errs.add("proxy_cert_mode", "must be %s, %s or %s", CertModeSelfCert, CertModeCustom, CertModeLocalCA)
+	}
+	certFile, keyFile := strings.TrimSpace(cfg.ProxyCertFile), strings.TrimSpace(cfg.ProxyKeyFile)
+	if certMode == CertModeCustom {
+		if certFile == "" {
+			errs.add("proxy_cert_file", "is required in custom cert mode")
+		}
+		if keyFile == "" {
+			errs.add("proxy_key_file", "is required in custom cert mode")
+		}
+	}
+	validateFile(&errs, "proxy_cert_file", certFile)
+	validateFile(&errs, "proxy_key_file", keyFile)
+
+	if cfg.ProxyMaxRestarts < 0 {
+		errs.add("proxy_max_restarts", "must not be negative")
+	}
+	if addr := strings.TrimSpace(cfg.ProxyAPIAddr); addr != "" {
+		if _, port, err := net.SplitHostPort(addr); err != nil {
+			errs.add("proxy_api_addr", "must be host:port")
+		} else if p, err := parsePort(port); err != nil || p == cfg.ProxyPort {
+			errs.add("proxy_api_addr", "needs a valid port different from proxy_port")
+		}
+	}
+
+	for _, spec := range cfg.AgentPlatforms {
+		if _, err := ParseLigoloPlatform(spec); err != nil {
+			errs.add("agent_platforms", "%v", err)
+		}
+	}
+	if v := strings.TrimSpace(cfg.LigoloVersion); v != "" {
+		if err := ValidateLigoloVersion(v); err != nil {
+			errs.add("ligolo_version", "%v", err)
+		}
+	}
+	if m := strings.TrimSpace(cfg.LigoloMirror); m != "" {
+		if u, err := url.Parse(m); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add("ligolo_mirror", "must be an http(s) URL")
+		}
+	}
+
+	validateBindIP(&errs, "file_bind", cfg.FileBind, true)
+	validatePort(&errs, "file_port", cfg.FilePort)
+	if cfg.FilePort == cfg.ProxyPort && cfg.FilePort != 0 {
+		errs.add("file_port", "is already used by proxy_port")
+	}
+	if dir := strings.TrimSpace(cfg.FileDirectory); dir != "" {
+		if fi, err := os.Stat(dir); err != nil {
+			errs.add("file_directory", "%s does not exist", dir)
+		} else if !fi.IsDir() {
+			errs.add("file_directory", "%s is not a directory", dir)
+		}
+	}
+
+	fileCert, fileKey := strings.TrimSpace(cfg.FileCertFile), strings.TrimSpace(cfg.FileKeyFile)
+	if (fileCert == "") != (fileKey == "") {
+		errs.add("file_cert_file", "file_cert_file and file_key_file must be set together")
+	}
+	validateFile(&errs, "file_cert_file", fileCert)
+	validateFile(&errs, "file_key_file", fileKey)
+	if cfg.FileUploadMaxMB < 0 {
+		errs.add("file_upload_max_mb", "must not be negative")
+	}
+	if cfg.FileLinkTTLMinutes < 0 {
+		errs.add("file_link_ttl_minutes", "must not be negative")
+	}
+	if cfg.FileLinkMaxDownloads < 0 {
+		errs.add("file_link_max_downloads", "must not be negative")
+	}
+
+	validateProxyInstances(&errs, cfg)
+	return errs
+}
+
+func validateProxyInstances(errs *FieldErrors, cfg Config) {
+	names := map[string]bool{DefaultProxyInstance: true}
+	ports := map[int]string{cfg.ProxyPort: "proxy_port", cfg.FilePort: "file_port"}
+	for i, inst := range cfg.ProxyInstances {
+		field := func(name string) string { return fmt.Sprintf("proxy_instances[%d].%s", i, name) }
+
+		name := strings.TrimSpace(inst.Name)
+		if err := ValidateProxyInstanceName(name); err != nil {
+			errs.add(field("name"), "%v", err)
+		} else if names[name] {
+			errs.add(field("name"), "%q is already used", name)
+		}
+		names[name] = true
+
+		if inst.Port <= 0 || inst.Port > 65535 {
+			errs.add(field("port"), "must be between 1 and 65535")
+		} else if other, ok := ports[inst.Port]; ok {
+			errs.add(field("port"), "%d is already used by %s", inst.Port, other)
+		} else {
+			ports[inst.Port] = field("port")
+		}
+
+		validateBindIP(errs, field("bind"), inst.Bind, false)
+		validateExecutable(errs, field("binary"), inst.Binary, false)
+		certFile, keyFile := strings.TrimSpace(inst.CertFile), strings.TrimSpace(inst.KeyFile)
+		if (certFile == "") != (keyFile == "") {
+			errs.add(field("cert_file"), "cert_file and key_file must be set together")
+		}
+		validateFile(errs, field("cert_file"), certFile)
+		validateFile(errs, field("key_file"), keyFile)
+	}
+}
+
+func validateBindIP(errs *FieldErrors, field, value string, required bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		if required {
+			errs.add(field, "is required (e.g. 0.0.0.0)")
+		}
+		return
+	}
+	if net.ParseIP(value) == nil {
+		errs.add(field, "%q is not an IP address", value)
+	}
+}
+
+func validatePort(errs *FieldErrors, field string, port int) {
+	if port <= 0 || port > 65535 {
+		errs.add(field, "must be between 1 and 65535")
+	}
+}
+
+func parsePort(s string) (int, error) {
+	p, err := strconv.Atoi(s)
+	if err != nil || p <= 0 || p > 65535 {
+		return 0, errors.New("invalid port")
+	}
+	return p, nil
+}
+
+func validateFile(errs *FieldErrors, field, path string) {
+	if path == "" {
+		return
+	}
+	if fi, err := os.Stat(path); err != nil {
+		errs.add(field, "%s does not exist", path)
+	} else if fi.IsDir() {
+		errs.add(field, "%s is a directory", path)
+	}
+}
+
+func validateExecutable(errs *FieldErrors, field, path string, required bool) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		if required {
+			errs.add(field, "is required")
+		}
+		return
+	}
+	fi, err := os.Stat(path)
+	switch {
+	case err != nil:
+		errs.add(field, "%s does not exist", path)
+	case fi.IsDir():
+		errs.add(field, "%s is a directory", path)
+	case runtime.GOOS != "windows" && fi.Mode().Perm()&0o111 == 0:
+		errs.add(field, "%s is not executable", path)
+	}
+}
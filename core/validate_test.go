@@ -0,0 +1,102 @@
+package core
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fieldMessages maps each failing field to its messages.
+func fieldMessages(errs FieldErrors) map[string]string {
+	out := map[string]string{}
+	for _, fe := range errs {
+		out[fe.Field] += fe.Message
+	}
+	return out
+}
+
+func TestValidateConfigPublicIP(t *testing.T) {
+	for value, ok := range map[string]bool{
+		"10.10.14.2":             true,
+		" 10.10.14.2 ":           true,
+		"fe80::1":                true,
+		defaultPublicIP:          true,
+		"vpn.example.com":        true,
+		"tunnelbox":              true,
+		"iface:tun0":             true,
+		"iface:":                 false,
+		"iface:tun0/24":          false,
+		"iface:a:b":              false,
+		"":                       false,
+		"10.10.14.2/24":          false,
+		"bad_host.example.com":   false,
+		"-leading.example.com":   false,
+		"http://vpn.example.com": false,
+	} {
+		cfg := DefaultConfig()
+		cfg.PublicIP = value
+		_, failed := fieldMessages(ValidateConfig(cfg))["public_ip"]
+		if failed == ok {
+			t.Errorf("public_ip %q valid = %v, want %v", value, !failed, ok)
+		}
+	}
+}
+
+func TestValidateConfigPortConflicts(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ProxyAPIAddr = "127.0.0.1:11601"
+	cfg.ProxyInstances = []ProxyInstance{
+		{Name: "second", Port: 11602},
+		{Name: "third", Port: 11602},
+		{Name: "fourth", Port: cfg.ProxyPort},
+		{Name: "second", Port: 70000},
+		{Name: DefaultProxyInstance, Port: 11605},
+	}
+	got := fieldMessages(ValidateConfig(cfg))
+	for field, want := range map[string]string{
+		"proxy_api_addr":          "different from proxy_port",
+		"proxy_instances[1].port": "already used by proxy_instances[0].port",
+		"proxy_instances[2].port": "already used by proxy_port",
+		"proxy_instances[3].port": "between 1 and 65535",
+		"proxy_instances[3].name": "already used",
+		"proxy_instances[4].name": "already used",
+	} {
+		if !strings.Contains(got[field], want) {
+			t.Errorf("%s = %q, want %q", field, got[field], want)
+		}
+	}
+	if msg, ok := got["proxy_instances[0].port"]; ok {
+		t.Errorf("first instance port rejected: %s", msg)
+	}
+
+	cfg = DefaultConfig()
+	cfg.FilePort = cfg.ProxyPort
+	if msg := fieldMessages(ValidateConfig(cfg))["file_port"]; !strings.Contains(msg, "already used by proxy_port") {
+		t.Errorf("file_port = %q", msg)
+	}
+}
+
+func TestFieldErrorsOnly(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ProxyBinary = filepath.Join(t.TempDir(), "missing-proxy")
+	cfg.ProxyInstances = []ProxyInstance{{Name: "second", Port: cfg.FilePort}}
+	errs := ValidateConfig(cfg)
+	if len(errs) != 2 {
+		t.Fatalf("errors = %v, want proxy_binary and the instance port", errs)
+	}
+
+	// A partial update is judged only on the fields it submits.
+	if only := errs.Only(map[string]bool{"file_links_only": true}); len(only) != 0 {
+		t.Errorf("unrelated update rejected: %v", only)
+	}
+	only := errs.Only(map[string]bool{"proxy_instances": true})
+	if len(only) != 1 || only[0].Field != "proxy_instances[0].port" {
+		t.Errorf("proxy_instances update errors = %v", only)
+	}
+	if only := errs.Only(map[string]bool{"proxy_binary": true, "proxy_instances": true}); len(only) != 2 {
+		t.Errorf("full update errors = %v", only)
+	}
+	if s := errs.Error(); !strings.Contains(s, "proxy_binary: ") || !strings.Contains(s, "; proxy_instances[0].port: ") {
+		t.Errorf("Error() = %q", s)
+	}
+}
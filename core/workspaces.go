@@ -0,0 +1,286 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultWorkspace is the workspace using the original global config file and
+// loot dir.
+const DefaultWorkspace = "default"
+
+var workspaceNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Workspace is an engagement with its own config, loot dir and scout results.
+type Workspace struct {
+	Name       string     `json:"name"`
+	Created    time.Time  `json:"created"`
+	Archived   bool       `json:"archived"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+	// Active, ConfigPath and LootDir are filled in by ListWorkspaces.
+	Active     bool   `json:"active,omitempty"`
+	ConfigPath string `json:"config_path,omitempty"`
+	LootDir    string `json:"loot_dir,omitempty"`
+}
+
+// workspaceIndex is the on-disk list of workspaces and the active one.
+type workspaceIndex struct {
+	Active     string      `json:"active"`
+	Workspaces []Workspace `json:"workspaces"`
+}
+
+// workspaceMu serializes read-modify-write cycles of the index.
+var workspaceMu sync.Mutex
+
+// ValidateWorkspaceName checks that name is usable as a workspace name. Names
+// end up in directory names, so the character set is limited.
+func ValidateWorkspaceName(name string) error {
+	if !workspaceNameRe.MatchString(name) {
+		return fmt.Errorf("invalid workspace name %q (use a-z, 0-9, - and _)", name)
+	}
+	return nil
+}
+
+func workspaceIndexPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "workspaces.json"), nil
+}
+
+func readWorkspaceIndex() (workspaceIndex, error) {
+	idx := workspaceIndex{Active: DefaultWorkspace}
+	path, err := workspaceIndexPath()
+	if err != nil {
+		return idx, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return idx, nil
+		}
+		return idx, err
+	}
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return workspaceIndex{Active: DefaultWorkspace}, fmt.Errorf("%s: %w", path, err)
+	}
+	if ValidateWorkspaceName(idx.Active) != nil {
+		idx.Active = DefaultWorkspace
+	}
+	return idx, nil
+}
+
+func writeWorkspaceIndex(idx workspaceIndex) error {
+	path, err := workspaceIndexPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o600)
+}
+
+func (idx workspaceIndex) find(name string) int {
+	for i, ws := range idx.Workspaces {
+		if ws.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// ActiveWorkspace returns the name of the active workspace, falling back to
+// the default workspace if the index is missing or unreadable.
+func ActiveWorkspace() string {
+	idx, err := readWorkspaceIndex()
+	if err != nil {
+		return DefaultWorkspace
+	}
+	if idx.Active != DefaultWorkspace && idx.find(idx.Active) < 0 {
+		return DefaultWorkspace
+	}
+	return idx.Active
+}
+
+// WorkspaceLootDir returns the loot dir of the named workspace.
+func WorkspaceLootDir(name string) (string, error) {
+	base, err := DefaultAppDataDir()
+	if err != nil {
+		return "", err
+	}
+	if name == DefaultWorkspace {
+		return filepath.Join(base, "loot"), nil
+	}
+	return filepath.Join(base, "workspaces", name, "loot"), nil
+}
+
+// WorkspaceLogDir returns the directory holding the logs of the named
+// workspace, next to its loot dir.
+func WorkspaceLogDir(name string) (string, error) {
+	lootDir, err := WorkspaceLootDir(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(lootDir), "logs"), nil
+}
+
+func workspaceConfigPath(name string) (string, error) {
+	if name == DefaultWorkspace {
+		return globalConfigPath()
+	}
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "workspaces", name, "config.json"), nil
+}
+
+// ListWorkspaces returns the default workspace followed by the others in
+// name order.
+func ListWorkspaces() ([]Workspace, error) {
+	idx, err := readWorkspaceIndex()
+	if err != nil {
+		return nil, err
+	}
+	active := ActiveWorkspace()
+
+	sort.Slice(idx.Workspaces, func(i, j int) bool { return idx.Workspaces[i].Name < idx.Workspaces[j].Name })
+	list := append([]Workspace{{Name: DefaultWorkspace}}, idx.Workspaces...)
+	for i := range list {
+		list[i].Active = list[i].Name == active
+		list[i].ConfigPath, _ = workspaceConfigPath(list[i].Name)
+		list[i].LootDir, _ = WorkspaceLootDir(list[i].Name)
+	}
+	return list, nil
+}
+
+// CreateWorkspace creates a workspace whose config starts with the proxy,
+// agent and TLS settings of the active one, with its own loot dir.
+func CreateWorkspace(name string) (Workspace, error) {
+	workspaceMu.Lock()
+	defer workspaceMu.Unlock()
+
+	if err := ValidateWorkspaceName(name); err != nil {
+		return Workspace{}, err
+	}
+	idx, err := readWorkspaceIndex()
+	if err != nil {
+		return Workspace{}, err
+	}
+	if name == DefaultWorkspace || idx.find(name) >= 0 {
+		return Workspace{}, fmt.Errorf("workspace %q already exists", name)
+	}
+
+	active, err := LoadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Workspace{}, err
+	}
+	cfg := workspaceSeedConfig(active)
+	lootDir, err := WorkspaceLootDir(name)
+	if err != nil {
+		return Workspace{}, err
+	}
+	if err := initLootDirAt(lootDir); err != nil {
+		return Workspace{}, err
+	}
+	cfg.FileDirectory = lootDir
+
+	cfgPath, err := workspaceConfigPath(name)
+	if err != nil {
+		return Workspace{}, err
+	}
+	if err := writeConfig(cfgPath, cfg); err != nil {
+		return Workspace{}, err
+	}
+
+	ws := Workspace{Name: name, Created: time.Now()}
+	idx.Workspaces = append(idx.Workspaces, ws)
+	if err := writeWorkspaceIndex(idx); err != nil {
+		return Workspace{}, err
+	}
+	ws.ConfigPath, ws.LootDir = cfgPath, lootDir
+	return ws, nil
+}
+
+// workspaceSeedConfig returns the defaults with the proxy, agent and TLS
+// settings of cfg. Secrets, certificate files, extra proxy instances and file
+// server settings belong to an engagement and start fresh; the secrets are
+// regenerated on first use.
+func workspaceSeedConfig(cfg Config) Config {
+	out := DefaultConfig()
+	out.ProxyBind, out.ProxyPort, out.PublicIP = cfg.ProxyBind, cfg.ProxyPort, cfg.PublicIP
+	out.ProxyBinary, out.AgentBinary = cfg.ProxyBinary, cfg.AgentBinary
+	if cfg.ProxyCertMode != CertModeCustom {
+		out.ProxyCertMode = cfg.ProxyCertMode
+	}
+	out.ProxyAutoRestart, out.ProxyMaxRestarts = cfg.ProxyAutoRestart, cfg.ProxyMaxRestarts
+	out.ProxyAPIEnabled, out.ProxyAPIAddr, out.ProxyAPIUser = cfg.ProxyAPIEnabled, cfg.ProxyAPIAddr, cfg.ProxyAPIUser
+	out.AgentPlatforms = append([]string(nil), cfg.AgentPlatforms...)
+	out.LigoloVersion, out.LigoloMirror = cfg.LigoloVersion, cfg.LigoloMirror
+	out.AgentPublish, out.AgentPublishRandom = cfg.AgentPublish, cfg.AgentPublishRandom
+	out.FileTLS = cfg.FileTLS
+	return out
+}
+
+// SwitchWorkspace makes the named workspace active.
+func SwitchWorkspace(name string) error {
+	workspaceMu.Lock()
+	defer workspaceMu.Unlock()
+
+	idx, err := readWorkspaceIndex()
+	if err != nil {
+		return err
+	}
+	if name != DefaultWorkspace {
+		i := idx.find(name)
+		if i < 0 {
+			return fmt.Errorf("unknown workspace %q", name)
+		}
+		if idx.Workspaces[i].Archived {
+			return fmt.Errorf("workspace %q is archived", name)
+		}
+	}
+	idx.Active = name
+	return writeWorkspaceIndex(idx)
+}
+
+// ArchiveWorkspace marks a workspace archived (or restores it). Archived
+// workspaces keep their data but can't be switched to. The default and the
+// active workspace can't be archived.
+func ArchiveWorkspace(name string, archived bool) error {
+	workspaceMu.Lock()
+	defer workspaceMu.Unlock()
+
+	idx, err := readWorkspaceIndex()
+	if err != nil {
+		return err
+	}
+	if name == DefaultWorkspace {
+		return errors.New("the default workspace can't be archived")
+	}
+	i := idx.find(name)
+	if i < 0 {
+		return fmt.Errorf("unknown workspace %q", name)
+	}
+	if archived && idx.Active == name {
+		return errors.New("switch to another workspace before archiving this one")
+	}
+
+	idx.Workspaces[i].Archived = archived
+	idx.Workspaces[i].ArchivedAt = nil
+	if archived {
+		now := time.Now()
+		idx.Workspaces[i].ArchivedAt = &now
+	}
+	return writeWorkspaceIndex(idx)
+}
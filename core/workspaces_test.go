@@ -0,0 +1,58 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateWorkspaceSeedsConfig(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.PublicIP = "iface:tun0"
+	cfg.ProxyPort = 12000
+	cfg.ProxyCertMode = CertModeCustom
+	cfg.ProxyCertFile, cfg.ProxyKeyFile = filepath.Join(dir, "op1.pem"), filepath.Join(dir, "op1-key.pem")
+	cfg.ProxyInstances = []ProxyInstance{{Name: "second", Port: 12001}}
+	cfg.ProxyAPIEnabled = true
+	cfg.ProxyAPIPassword = "op1-api-password"
+	cfg.AgentPlatforms = []string{"windows/amd64"}
+	cfg.FileUpload = true
+	cfg.FileUploadToken = "op1-upload-token"
+	cfg.FileTLS = true
+	cfg.FileCertFile, cfg.FileKeyFile = filepath.Join(dir, "files.pem"), filepath.Join(dir, "files-key.pem")
+	cfg.FileLinksOnly = true
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err := CreateWorkspace("op2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SwitchWorkspace("op2"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.PublicIP != cfg.PublicIP || got.ProxyPort != 12000 || !got.ProxyAPIEnabled || !got.FileTLS ||
+		len(got.AgentPlatforms) != 1 || got.AgentPlatforms[0] != "windows/amd64" {
+		t.Errorf("proxy, agent or TLS settings not copied: %+v", got)
+	}
+	if got.ProxyAPIPassword != "" || got.FileUploadToken != "" {
+		t.Error("secrets copied into the new workspace")
+	}
+	if got.ProxyCertFile != "" || got.ProxyKeyFile != "" || got.ProxyCertMode != CertModeSelfCert ||
+		got.FileCertFile != "" || got.FileKeyFile != "" {
+		t.Errorf("certificate files copied: %q %q %q %q %q", got.ProxyCertMode, got.ProxyCertFile, got.ProxyKeyFile, got.FileCertFile, got.FileKeyFile)
+	}
+	if len(got.ProxyInstances) != 0 || got.FileUpload || got.FileLinksOnly {
+		t.Errorf("engagement state copied: instances %v, upload %v, links only %v", got.ProxyInstances, got.FileUpload, got.FileLinksOnly)
+	}
+	if got.FileDirectory != ws.LootDir {
+		t.Errorf("file_directory %q, want the workspace loot dir %q", got.FileDirectory, ws.LootDir)
+	}
+}
@@ -1,3 +1,7 @@
 module github.com/alardiians/SwissArmyToolkit
 
 go 1.23.5
+
+require golang.org/x/crypto v0.41.0
+
+require golang.org/x/sys v0.35.0 // indirect